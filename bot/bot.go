@@ -2,11 +2,16 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/caiofilipini/got/irc"
+	"github.com/caiofilipini/got/irc/format"
 )
 
 const (
@@ -20,6 +25,10 @@ const (
 	HelpCommand = `(?i)help\s*(.*)`
 )
 
+// How many requests can wait while a command runs; the
+// ones received past that are dropped.
+const maxPendingRequests = 32
+
 // Command is the interface that registered commands need to
 // implement in order to receive messages.
 type Command interface {
@@ -41,85 +50,332 @@ type Command interface {
 	Run(string) []string
 }
 
+// Scope defines where a command can be used.
+type Scope int
+
+const (
+	// Anywhere allows the command to be used both in channels
+	// and in private messages.
+	Anywhere Scope = iota
+
+	// ChannelOnly restricts the command to channels.
+	ChannelOnly
+
+	// PrivateOnly restricts the command to private messages.
+	PrivateOnly
+)
+
+// ScopedCommand is an optional interface that commands can
+// implement in order to restrict where they can be used.
+// Commands that don't implement it can be used anywhere.
+type ScopedCommand interface {
+	// Scope returns where the command can be used.
+	Scope() Scope
+}
+
+// Request holds the details of a request sent to the bot.
+type Request struct {
+	// The channel where the request was sent, or an empty
+	// string if it was sent in a private message.
+	Channel string
+
+	// The nickname of the user who sent the request.
+	Sender string
+}
+
+// State gives access to the channels the bot is in,
+// their members, and what is known about them.
+type State interface {
+	// ChannelUsers returns the members of the given channel.
+	ChannelUsers(channel string) []irc.Member
+
+	// ChannelMember returns the given user as a member of
+	// the given channel, or false if the user is not in it.
+	ChannelMember(channel, nick string) (irc.Member, bool)
+
+	// User returns what is known about the given user, or false
+	// if the user shares no channel with the bot.
+	User(nick string) (irc.User, bool)
+
+	// UserChannels returns the channels the given user shares
+	// with the bot.
+	UserChannels(nick string) []string
+
+	// Lag returns the round-trip time to the server, or false
+	// if it hasn't been measured yet.
+	Lag() (time.Duration, bool)
+
+	// Capabilities returns the capabilities negotiated with
+	// the server, along with their values.
+	Capabilities() map[string]string
+
+	// HasCapability checks if the given capability has been
+	// negotiated with the server (e.g. to adapt the answers).
+	HasCapability(name string) bool
+}
+
+// StatefulCommand is an optional interface that commands can
+// implement in order to know who sent a request and who else is
+// around (e.g. for permission checks). RunWithState is called
+// instead of Run for commands that implement it.
+type StatefulCommand interface {
+	// RunWithState receives a query, the details of the request
+	// and the current state, and returns a list of messages
+	// to be sent in response.
+	RunWithState(string, Request, State) []string
+}
+
 // Bot represents a running instance of the bot.
 type Bot struct {
 	// The IRC connection.
-	irc irc.IRC
+	irc *irc.IRC
 
-	// The user representing the bot in the IRC channel.
+	// The user representing the bot in the IRC channels.
 	user string
 
-	// The password for the IRC channel (if applicable).
-	passwd string
+	// The registered commands, possibly shared with other bots.
+	registry *Registry
 
-	// The list of registered commands.
-	commands []Command
-
-	// A map where the key is the command name, and the
-	// value is the command itself.
-	commandsByName map[string]Command
+	// A map where the key is the name of a command enabled for
+	// this bot. If empty, all the registered commands are.
+	enabled map[string]bool
 
 	// The regexp pattern that matches the action to trigger
 	// the bot.
 	action *regexp.Regexp
 
+	// The filter that matches channel messages addressed to
+	// the bot.
+	filter irc.Filter
+
 	// The regexp pattern that matches the help command.
 	helpPattern *regexp.Regexp
 
-	// The channel where filtered requests are sent.
-	request chan string
-
 	// The channel where messages that match the configured
-	// action are sent.
-	in chan string
+	// action are sent. It's owned by the IRC subscription,
+	// which closes it when the connection is closed.
+	in chan irc.Message
+
+	// Closed when the bot is shut down.
+	stop chan struct{}
+
+	// Makes sure the stop channel is closed only once.
+	stopOnce *sync.Once
+
+	// Tracks the running instances of Run, so that
+	// shutting down waits for them to finish.
+	running *sync.WaitGroup
+}
+
+// request represents a request sent to the bot.
+type request struct {
+	// Where the response should be sent to.
+	target string
+
+	// The nickname of the user who sent the request.
+	sender string
+
+	// The request text, without the action.
+	text string
+
+	// Whether the request was sent in a private message.
+	private bool
 }
 
 // NewBot creates and return a value representing
 // a connected bot.
-func NewBot(irc irc.IRC, user, passwd string) Bot {
+func NewBot(conn *irc.IRC, user string) Bot {
+	return NewBotWithRegistry(conn, user, NewRegistry())
+}
+
+// NewBotWithRegistry creates and return a value representing
+// a connected bot, which uses the commands in the given registry.
+func NewBotWithRegistry(conn *irc.IRC, user string, registry *Registry) Bot {
 	return Bot{
-		irc:            irc,
-		user:           user,
-		passwd:         passwd,
-		commands:       make([]Command, 0),
-		commandsByName: make(map[string]Command),
-		action:         regexp.MustCompile(fmt.Sprintf("PRIVMSG %s :%s (.*)", irc.Channel, Action)),
-		helpPattern:    regexp.MustCompile(HelpCommand),
-		request:        make(chan string),
-		in:             make(chan string),
+		irc:         conn,
+		user:        user,
+		registry:    registry,
+		enabled:     make(map[string]bool),
+		action:      regexp.MustCompile(fmt.Sprintf(`^%s\s+(.*)`, regexp.QuoteMeta(Action))),
+		filter:      requestFilter(conn),
+		helpPattern: regexp.MustCompile(HelpCommand),
+		in:          make(chan irc.Message, maxPendingRequests),
+		stop:        make(chan struct{}),
+		stopOnce:    &sync.Once{},
+		running:     &sync.WaitGroup{},
 	}
 }
 
-// Register registers the given command.
+// Register registers the given command. If the registry is
+// shared, the command is registered for the other bots as well.
 func (bot *Bot) Register(command Command) {
-	bot.commands = append(bot.commands, command)
-	bot.commandsByName[command.Name()] = command
+	bot.registry.Register(command)
+}
+
+// Enable restricts the bot to the registered commands with the
+// given names, e.g. so that bots sharing a registry each answer
+// to different commands. It returns an error if any of them is
+// not registered.
+func (bot *Bot) Enable(names ...string) error {
+	if err := bot.registry.Check(names...); err != nil {
+		return err
+	}
+	for _, name := range names {
+		bot.enabled[name] = true
+	}
+	return nil
+}
+
+// commands returns the registered commands enabled for the bot.
+func (bot Bot) commands() []Command {
+	all := bot.registry.Commands()
+	if len(bot.enabled) == 0 {
+		return all
+	}
+
+	var commands []Command
+	for _, c := range all {
+		if bot.enabled[c.Name()] {
+			commands = append(commands, c)
+		}
+	}
+	return commands
+}
+
+// command returns the enabled command with the given name,
+// or false if there's no such command.
+func (bot Bot) command(name string) (Command, bool) {
+	c, found := bot.registry.Lookup(name)
+	if !found || (len(bot.enabled) > 0 && !bot.enabled[name]) {
+		return nil, false
+	}
+	return c, true
 }
 
-// Start joins the channel, sends a welcome message and
-// subscribes to messages that match the configured action.
-func (bot Bot) Start() {
-	bot.irc.Subscribe(bot.action, bot.in)
-	bot.irc.Join(bot.user, bot.passwd)
-	bot.irc.SendMessages(WelcomeMsg)
+// Start joins the channels, sends a welcome message to each
+// of them and subscribes to messages that match the configured
+// action, as well as to private messages. It returns an error
+// if the bot could not join.
+func (bot Bot) Start() error {
+	bot.irc.OnConnect(func() {
+		info(fmt.Sprintf("Connected, joining channels %v", bot.irc.ConfiguredChannels()))
+	})
+	bot.irc.OnDisconnect(func(err error) {
+		info(fmt.Sprintf("Disconnected: %v", err))
+	})
+
+	// Requests are queued while a command runs, so that a slow
+	// command doesn't hold up the connection (e.g. PINGs), up to
+	// a limit, so that a flood of requests can't pile up.
+	bot.irc.SubscribeWith(bot.filter, bot.in, irc.DropOnFull)
+	if err := bot.irc.Join(bot.user); err != nil {
+		return err
+	}
+	for _, channel := range bot.irc.ConfiguredChannels() {
+		bot.irc.SendMessages(channel, WelcomeMsg)
+	}
+	return nil
 }
 
-// Listen starts a background process to listen to
-// incoming requests.
+// Listen listens to incoming requests until the bot is
+// shut down or the IRC connection is closed.
 func (bot Bot) Listen() {
-	go bot.handleRequests()
+	bot.Run(context.Background())
+}
+
+// Run listens to incoming requests until the given context is
+// done, returning its error, or until the bot is shut down or the
+// IRC connection is closed. Before returning, it waits for the
+// request being handled, if any, to be answered.
+func (bot Bot) Run(ctx context.Context) error {
+	bot.running.Add(1)
+	defer bot.running.Done()
+
+	select {
+	case <-bot.stop:
+		return nil
+	default:
+	}
+
+	requests := make(chan request)
+	handled := make(chan struct{})
+	go func() {
+		bot.handleRequests(requests)
+		close(handled)
+	}()
+	defer func() {
+		close(requests)
+		<-handled
+	}()
+
+	for {
+		select {
+		case msg, ok := <-bot.in:
+			if !ok {
+				return nil
+			}
+			if r, ok := bot.parseRequest(msg); ok {
+				select {
+				case requests <- r:
+				case <-bot.stop:
+					return nil
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		case <-bot.stop:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Shutdown stops listening to incoming requests and waits for
+// the ones being handled to be answered, or for the given context
+// to be done, in which case its error is returned. The IRC
+// connection should be shut down afterwards, so that the answers
+// are sent before quitting.
+func (bot Bot) Shutdown(ctx context.Context) error {
+	bot.stopOnce.Do(func() { close(bot.stop) })
+
+	stopped := make(chan struct{})
+	go func() {
+		bot.running.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
 
-	for msg := range bot.in {
-		if req := bot.action.FindStringSubmatch(msg); len(req) > 1 {
-			bot.request <- req[1]
+// parseRequest extracts the request from the given message,
+// and reports whether the message contains one. Formatting
+// codes (e.g. colors) are stripped, so that they don't get in
+// the way of matching commands.
+func (bot Bot) parseRequest(msg irc.Message) (request, bool) {
+	text := format.Strip(msg.Trailing)
+	if target := msg.Arg(0); irc.IsChannel(target) {
+		if req := bot.action.FindStringSubmatch(text); len(req) > 1 {
+			return request{target, msg.Prefix.Nick, req[1], false}, true
 		}
+	} else if text := bot.privateText(text); text != "" {
+		return request{msg.Prefix.Nick, msg.Prefix.Nick, text, true}, true
 	}
+	return request{}, false
 }
 
-// Shutdown closes the incoming request channels.
-func (bot Bot) Shutdown() {
-	close(bot.in)
-	close(bot.request)
+// privateText returns the request text of a private message,
+// for which the action is optional.
+func (bot Bot) privateText(text string) string {
+	if req := bot.action.FindStringSubmatch(text); len(req) > 1 {
+		return req[1]
+	}
+	return strings.TrimSpace(text)
 }
 
 // recognise verifies if the given request is a recognised command.
@@ -127,7 +383,7 @@ func (bot Bot) Shutdown() {
 // the query part of the request, and a nil error;
 // if the command is not recognised, returns an error.
 func (bot Bot) recognise(request string) (Command, string, error) {
-	for _, c := range bot.commands {
+	for _, c := range bot.commands() {
 		if match := c.Pattern().FindStringSubmatch(request); len(match) > 0 {
 			return c, match[len(match)-1], nil
 		}
@@ -136,48 +392,123 @@ func (bot Bot) recognise(request string) (Command, string, error) {
 }
 
 // showHelp formats and sends a help message containing
-// a list of all registered commands. If a command is given,
-// shows the usage information for that command.
-func (bot Bot) showHelp(command string) {
+// a list of all registered commands that can be used where
+// the request came from. If a command is given, shows the
+// usage information for that command.
+func (bot Bot) showHelp(r request, command string) {
 	var helpMessages []string
 
+	decorate := formatHelp
+	if r.private {
+		decorate = func(messages ...string) []string { return messages }
+	}
+
 	if command != "" {
-		if c, found := bot.commandsByName[command]; found {
-			helpMessages = append(helpMessages, formatHelp(c.Usage()...)...)
+		if c, found := bot.command(command); found {
+			helpMessages = append(helpMessages, decorate(c.Usage()...)...)
 		} else {
 			helpMessages = append(helpMessages, "unknown command: "+command)
 		}
 	} else {
-		for _, c := range bot.commands {
-			helpMessages = append(helpMessages, formatHelp(c.Help())...)
+		for _, c := range bot.commands() {
+			if allowed(c, r.private) {
+				helpMessages = append(helpMessages, decorate(c.Help())...)
+			}
 		}
 		helpHelp := []string{
 			"help – displays this message",
 			"help <command> – displays usage for the given command",
 		}
-		helpMessages = append(helpMessages, formatHelp(helpHelp...)...)
+		helpMessages = append(helpMessages, decorate(helpHelp...)...)
 	}
-	bot.irc.SendMessages(helpMessages...)
+	bot.irc.SendMessages(r.target, helpMessages...)
 }
 
 // handleRequests runs in the background and handles requests
-// sent to the request channel.
-func (bot Bot) handleRequests() {
-	for r := range bot.request {
-		info(fmt.Sprintf("Received request: %s", r))
+// sent to the given channel, until it's closed.
+func (bot Bot) handleRequests(requests chan request) {
+	for r := range requests {
+		info(fmt.Sprintf("Received request from %s: %s", r.target, r.text))
 
-		if match := bot.helpPattern.FindStringSubmatch(r); len(match) > 0 {
+		if match := bot.helpPattern.FindStringSubmatch(r.text); len(match) > 0 {
 			command := match[len(match)-1]
-			bot.showHelp(command)
-		} else if command, query, err := bot.recognise(r); err == nil {
-			messages := command.Run(query)
-			bot.irc.SendMessages(messages...)
+			bot.showHelp(r, command)
+		} else if command, query, err := bot.recognise(r.text); err == nil {
+			if !allowed(command, r.private) {
+				bot.irc.SendMessages(r.target, restrictedMessage(command))
+				continue
+			}
+			messages := bot.run(command, query, r)
+			bot.irc.SendMessages(r.target, messages...)
 		} else {
 			info(fmt.Sprintf("WARNING: %s", err.Error()))
 		}
 	}
 }
 
+// run runs the given command, passing it the details of the
+// request and the current state if it needs them.
+func (bot Bot) run(command Command, query string, r request) []string {
+	stateful, ok := command.(StatefulCommand)
+	if !ok {
+		return command.Run(query)
+	}
+
+	req := Request{Sender: r.sender}
+	if !r.private {
+		req.Channel = r.target
+	}
+	return stateful.RunWithState(query, req, bot.irc)
+}
+
+// requestFilter returns a filter that matches messages sent
+// to any channel which start with the configured action, as well
+// as private messages sent to the bot. Messages sent by the bot
+// itself, which are echoed back when the echo-message capability
+// is enabled, and CTCP messages, which are handled by the IRC
+// connection, are ignored.
+func requestFilter(conn *irc.IRC) irc.Filter {
+	return irc.MatchAll(
+		irc.MatchCommand("PRIVMSG"),
+		func(msg irc.Message) bool {
+			if strings.EqualFold(msg.Prefix.Nick, conn.Nick()) {
+				return false
+			}
+			if _, ok := msg.CTCP(); ok {
+				return false
+			}
+			return !irc.IsChannel(msg.Arg(0)) ||
+				strings.HasPrefix(format.Strip(msg.Trailing), Action)
+		},
+	)
+}
+
+// allowed checks if the given command can be used in
+// a private message or in a channel.
+func allowed(command Command, private bool) bool {
+	scoped, ok := command.(ScopedCommand)
+	if !ok {
+		return true
+	}
+
+	switch scoped.Scope() {
+	case ChannelOnly:
+		return !private
+	case PrivateOnly:
+		return private
+	}
+	return true
+}
+
+// restrictedMessage returns the message explaining where
+// the given command can be used.
+func restrictedMessage(command Command) string {
+	if scoped, ok := command.(ScopedCommand); ok && scoped.Scope() == PrivateOnly {
+		return command.Name() + " can only be used in private messages"
+	}
+	return command.Name() + " can only be used in channels"
+}
+
 // formatHelp adds the action string to all the help
 // messages for clarity.
 func formatHelp(messages ...string) []string {
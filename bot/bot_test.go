@@ -0,0 +1,309 @@
+package bot_test
+
+import (
+	"context"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/caiofilipini/got/bot"
+	"github.com/caiofilipini/got/command"
+	"github.com/caiofilipini/got/irc"
+	"github.com/caiofilipini/got/irc/irctest"
+	"github.com/stretchr/testify/assert"
+)
+
+// startBot connects a bot with the greet command to a fake server,
+// in the #got channel, and waits for it to say hello.
+func startBot(t *testing.T) *irctest.Server {
+	registry := bot.NewRegistry()
+	registry.Register(command.Greet())
+
+	return startBotWith(t, registry)
+}
+
+// startBotWith works like startBot, using the commands with the
+// given names from the given registry (all of them, if none).
+// The bot requests multi-prefix, if the server supports it.
+func startBotWith(t *testing.T, registry *bot.Registry, commands ...string) *irctest.Server {
+	server := irctest.NewServer()
+
+	config := server.Config()
+	config.Channels = []irc.ChannelConfig{{Name: "#got"}}
+	config.Capabilities = []string{irc.CapMultiPrefix}
+
+	conn, err := irc.NewIRC(config)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+
+	b := bot.NewBotWithRegistry(conn, "got", registry)
+	if !assert.Nil(t, b.Enable(commands...)) {
+		t.FailNow()
+	}
+	if !assert.Nil(t, b.Start()) {
+		t.FailNow()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go b.Run(ctx)
+
+	t.Cleanup(func() {
+		cancel()
+		conn.Close()
+		server.Close()
+	})
+
+	expect(t, server, "PRIVMSG", "#got", bot.WelcomeMsg)
+	return server
+}
+
+// expect waits for the bot to send the given message.
+func expect(t *testing.T, server *irctest.Server, command, target, text string) {
+	_, err := server.WaitFor(func(msg irc.Message) bool {
+		return msg.Command == command && msg.Arg(0) == target && msg.Trailing == text
+	})
+	assert.Nil(t, err, "expected %s %s :%s", command, target, text)
+}
+
+func TestBotJoinsAndSaysHello(t *testing.T) {
+	server := startBot(t)
+
+	assert.Equal(t, []string{"@got"}, server.Members("#got"))
+}
+
+func TestBotRunsCommandsInChannels(t *testing.T) {
+	server := startBot(t)
+	server.AddUser("marvin", "#got")
+
+	server.Say("marvin", "#got", "!got greet arthur")
+	expect(t, server, "PRIVMSG", "#got", "ohai there, arthur!")
+}
+
+func TestBotStripsFormatting(t *testing.T) {
+	server := startBot(t)
+	server.AddUser("marvin", "#got")
+
+	server.Say("marvin", "#got", "\x02!got\x02 \x0304greet\x03 \x1darthur\x1d")
+	expect(t, server, "PRIVMSG", "#got", "ohai there, arthur!")
+}
+
+func TestBotIgnoresMessagesWithoutAction(t *testing.T) {
+	server := startBot(t)
+	server.AddUser("marvin", "#got")
+
+	server.Say("marvin", "#got", "greet arthur")
+	server.Say("marvin", "#got", "!got greet ford")
+	expect(t, server, "PRIVMSG", "#got", "ohai there, ford!")
+
+	for _, msg := range server.Received() {
+		assert.NotEqual(t, "ohai there, arthur!", msg.Trailing)
+	}
+}
+
+func TestBotShowsHelp(t *testing.T) {
+	server := startBot(t)
+	server.AddUser("marvin", "#got")
+
+	server.Say("marvin", "#got", "!got help")
+	expect(t, server, "PRIVMSG", "#got", "!got greet – shows greetings")
+	expect(t, server, "PRIVMSG", "#got", "!got help – displays this message")
+
+	server.Say("marvin", "#got", "!got help greet")
+	expect(t, server, "PRIVMSG", "#got", "!got greet <nickname>")
+}
+
+func TestBotAnswersPrivateMessages(t *testing.T) {
+	server := startBot(t)
+	server.AddUser("marvin")
+
+	server.Say("marvin", "got", "help")
+	expect(t, server, "PRIVMSG", "marvin", "help – displays this message")
+
+	server.Say("marvin", "got", "greet arthur")
+	expect(t, server, "PRIVMSG", "marvin", "ohai there, arthur!")
+}
+
+// scopedCommand is a command that can only be used
+// where its scope allows.
+type scopedCommand struct {
+	name  string
+	scope bot.Scope
+}
+
+func (c scopedCommand) Name() string            { return c.name }
+func (c scopedCommand) Pattern() *regexp.Regexp { return regexp.MustCompile(`^` + c.name + `$`) }
+func (c scopedCommand) Help() string            { return c.name + " – has a scope" }
+func (c scopedCommand) Usage() []string         { return []string{c.name} }
+func (c scopedCommand) Scope() bot.Scope        { return c.scope }
+func (c scopedCommand) Run(query string) []string {
+	return []string{c.name + " done"}
+}
+
+func TestBotRestrictsScopedCommands(t *testing.T) {
+	registry := bot.NewRegistry()
+	registry.Register(scopedCommand{"public", bot.ChannelOnly})
+	registry.Register(scopedCommand{"secret", bot.PrivateOnly})
+
+	server := startBotWith(t, registry)
+	server.AddUser("marvin", "#got")
+
+	server.Say("marvin", "got", "public")
+	expect(t, server, "PRIVMSG", "marvin", "public can only be used in channels")
+	server.Say("marvin", "#got", "!got public")
+	expect(t, server, "PRIVMSG", "#got", "public done")
+
+	server.Say("marvin", "#got", "!got secret")
+	expect(t, server, "PRIVMSG", "#got", "secret can only be used in private messages")
+	server.Say("marvin", "got", "secret")
+	expect(t, server, "PRIVMSG", "marvin", "secret done")
+}
+
+func TestBotRejoinsAfterDisconnect(t *testing.T) {
+	server := startBot(t)
+
+	// The first JOIN is the one sent before disconnecting.
+	expect(t, server, "JOIN", "#got", "")
+	server.Disconnect()
+	expect(t, server, "JOIN", "#got", "")
+
+	server.AddUser("marvin", "#got")
+	server.Say("marvin", "#got", "!got greet arthur")
+	expect(t, server, "PRIVMSG", "#got", "ohai there, arthur!")
+}
+
+func TestBotsShareRegistry(t *testing.T) {
+	registry := bot.NewRegistry()
+	registry.Register(command.Greet())
+	registry.Register(command.Lag())
+
+	greeter := startBotWith(t, registry, "greet")
+	greeter.AddUser("marvin", "#got")
+	lagger := startBotWith(t, registry, "lag")
+	lagger.AddUser("marvin", "#got")
+
+	greeter.Say("marvin", "#got", "!got help")
+	expect(t, greeter, "PRIVMSG", "#got", "!got greet – shows greetings")
+	expect(t, greeter, "PRIVMSG", "#got", "!got help – displays this message")
+
+	lagger.Say("marvin", "#got", "!got help greet")
+	expect(t, lagger, "PRIVMSG", "#got", "unknown command: greet")
+
+	lagger.Say("marvin", "#got", "!got greet arthur")
+	lagger.Say("marvin", "#got", "!got lag")
+	expect(t, lagger, "PRIVMSG", "#got", "lag not measured yet")
+	for _, msg := range lagger.Received() {
+		assert.NotEqual(t, "ohai there, arthur!", msg.Trailing)
+	}
+}
+
+func TestEnableUnknownCommand(t *testing.T) {
+	b := bot.NewBotWithRegistry(nil, "got", bot.NewRegistry())
+
+	assert.NotNil(t, b.Enable("greet"))
+}
+
+// slowCommand is a command that doesn't answer until released.
+type slowCommand struct {
+	release chan struct{}
+}
+
+func (c slowCommand) Name() string            { return "slow" }
+func (c slowCommand) Pattern() *regexp.Regexp { return regexp.MustCompile(`^slow$`) }
+func (c slowCommand) Help() string            { return "slow – takes its time" }
+func (c slowCommand) Usage() []string         { return []string{"slow"} }
+
+func (c slowCommand) Run(query string) []string {
+	<-c.release
+	return []string{"done"}
+}
+
+func TestSlowCommandDoesNotDelayPong(t *testing.T) {
+	slow := slowCommand{make(chan struct{})}
+	registry := bot.NewRegistry()
+	registry.Register(slow)
+
+	server := startBotWith(t, registry)
+	server.AddUser("marvin", "#got")
+
+	// One request runs, one waits to be handled,
+	// and the rest are queued.
+	for i := 0; i < 3; i++ {
+		server.Say("marvin", "#got", "!got slow")
+	}
+	server.Send("PING :still there?")
+	expect(t, server, "PONG", "still there?", "still there?")
+
+	close(slow.release)
+	for i := 0; i < 3; i++ {
+		expect(t, server, "PRIVMSG", "#got", "done")
+	}
+}
+
+func TestSlowCommandFloodIsDropped(t *testing.T) {
+	slow := slowCommand{make(chan struct{})}
+	registry := bot.NewRegistry()
+	registry.Register(slow)
+
+	server := startBotWith(t, registry)
+	server.AddUser("marvin", "#got")
+
+	for i := 0; i < 200; i++ {
+		server.Say("marvin", "#got", "!got slow")
+	}
+	server.Send("PING :still there?")
+	expect(t, server, "PONG", "still there?", "still there?")
+
+	// Let the requests that weren't dropped run, one at a time.
+	answered := 0
+	for released := true; released; {
+		select {
+		case slow.release <- struct{}{}:
+			answered++
+		case <-time.After(100 * time.Millisecond):
+			released = false
+		}
+	}
+	assert.True(t, answered > 0)
+	assert.True(t, answered < 50, "answered %d requests", answered)
+}
+
+// capsCommand is a command that tells whether the
+// capability it's asked about was negotiated.
+type capsCommand struct{}
+
+func (c capsCommand) Name() string            { return "caps" }
+func (c capsCommand) Pattern() *regexp.Regexp { return regexp.MustCompile(`^caps\s+(\S+)$`) }
+func (c capsCommand) Help() string            { return "caps – checks a capability" }
+func (c capsCommand) Usage() []string         { return []string{"caps <capability>"} }
+func (c capsCommand) Run(query string) []string {
+	return nil
+}
+
+func (c capsCommand) RunWithState(query string, r bot.Request, state bot.State) []string {
+	if state.HasCapability(query) {
+		return []string{query + " enabled"}
+	}
+	return []string{query + " disabled"}
+}
+
+func TestStateExposesCapabilities(t *testing.T) {
+	registry := bot.NewRegistry()
+	registry.Register(capsCommand{})
+
+	server := startBotWith(t, registry)
+	server.AddUser("marvin", "#got")
+
+	server.Say("marvin", "#got", "!got caps multi-prefix")
+	expect(t, server, "PRIVMSG", "#got", "multi-prefix disabled")
+
+	// The capabilities are negotiated again after reconnecting.
+	expect(t, server, "JOIN", "#got", "")
+	server.SetCapabilities(irc.CapMultiPrefix)
+	server.Disconnect()
+	expect(t, server, "JOIN", "#got", "")
+
+	server.AddUser("marvin", "#got")
+	server.Say("marvin", "#got", "!got caps multi-prefix")
+	expect(t, server, "PRIVMSG", "#got", "multi-prefix enabled")
+}
@@ -0,0 +1,73 @@
+package bot
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Registry holds a set of commands, which can be shared
+// by several bots (e.g. connected to different networks).
+type Registry struct {
+	// Guards the fields below.
+	mu sync.RWMutex
+
+	// The registered commands, in the order they were registered.
+	commands []Command
+
+	// A map where the key is the command name, and the
+	// value is the command itself.
+	byName map[string]Command
+}
+
+// NewRegistry creates an empty command registry.
+func NewRegistry() *Registry {
+	return &Registry{byName: make(map[string]Command)}
+}
+
+// Register registers the given command, replacing the
+// one with the same name, if any.
+func (r *Registry) Register(command Command) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, found := r.byName[command.Name()]; found {
+		for i, c := range r.commands {
+			if c.Name() == command.Name() {
+				r.commands[i] = command
+			}
+		}
+	} else {
+		r.commands = append(r.commands, command)
+	}
+	r.byName[command.Name()] = command
+}
+
+// Lookup returns the command with the given name, or false
+// if there's no such command.
+func (r *Registry) Lookup(name string) (Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	c, found := r.byName[name]
+	return c, found
+}
+
+// Commands returns the registered commands, in the order
+// they were registered.
+func (r *Registry) Commands() []Command {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return append([]Command{}, r.commands...)
+}
+
+// Check returns an error if any of the commands with the
+// given names is not registered.
+func (r *Registry) Check(names ...string) error {
+	for _, name := range names {
+		if _, found := r.Lookup(name); !found {
+			return fmt.Errorf("unknown command: %s", name)
+		}
+	}
+	return nil
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"regexp"
 	"time"
+
+	"github.com/caiofilipini/got/irc/format"
 )
 
 const (
@@ -73,7 +75,8 @@ func (c BeerOClockCommand) Run(query string) []string {
 				secondDiff)
 		}
 	} else if beerOclock {
-		result = fmt.Sprintf("YES! %s%s%s", Beer, Beer, Beer)
+		var b format.Builder
+		result = b.Bold("YES!").Text(" " + Beer + Beer + Beer).String()
 	} else {
 		result = "Not yet. :("
 	}
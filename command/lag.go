@@ -0,0 +1,49 @@
+package command
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/caiofilipini/got/bot"
+)
+
+type LagCommand struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+func Lag() LagCommand {
+	return LagCommand{
+		"lag",
+		regexp.MustCompile(`(?i)^lag\s*$`),
+	}
+}
+
+func (c LagCommand) Name() string {
+	return c.name
+}
+
+func (c LagCommand) Pattern() *regexp.Regexp {
+	return c.pattern
+}
+
+func (c LagCommand) Help() string {
+	return c.name + " – shows the lag to the IRC server"
+}
+
+func (c LagCommand) Usage() []string {
+	return []string{c.name}
+}
+
+func (c LagCommand) Run(query string) []string {
+	return []string{"lag not measured yet"}
+}
+
+func (c LagCommand) RunWithState(query string, r bot.Request, state bot.State) []string {
+	lag, ok := state.Lag()
+	if !ok {
+		return c.Run(query)
+	}
+	return []string{fmt.Sprintf("lag: %s", lag.Round(time.Millisecond))}
+}
@@ -0,0 +1,50 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/caiofilipini/got/bot"
+	"github.com/caiofilipini/got/irc"
+	"github.com/stretchr/testify/assert"
+)
+
+// lagState is a bot.State that only knows the lag.
+type lagState struct {
+	lag      time.Duration
+	measured bool
+}
+
+func (s lagState) ChannelUsers(channel string) []irc.Member { return nil }
+
+func (s lagState) ChannelMember(channel, nick string) (irc.Member, bool) {
+	return irc.Member{}, false
+}
+
+func (s lagState) User(nick string) (irc.User, bool) { return irc.User{}, false }
+
+func (s lagState) UserChannels(nick string) []string { return nil }
+
+func (s lagState) Lag() (time.Duration, bool) { return s.lag, s.measured }
+
+func (s lagState) Capabilities() map[string]string { return nil }
+
+func (s lagState) HasCapability(name string) bool { return false }
+
+func TestLagPattern(t *testing.T) {
+	p := Lag().Pattern()
+
+	assert.Regexp(t, p, "lag")
+	assert.Regexp(t, p, "LAG ")
+	assert.NotRegexp(t, p, "lagging")
+}
+
+func TestLagRunWithState(t *testing.T) {
+	r := bot.Request{Channel: "#got", Sender: "marvin"}
+
+	result := Lag().RunWithState("", r, lagState{1234567 * time.Microsecond, true})
+	assert.Equal(t, []string{"lag: 1.235s"}, result)
+
+	result = Lag().RunWithState("", r, lagState{})
+	assert.Equal(t, []string{"lag not measured yet"}, result)
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"math"
 	"regexp"
+
+	"github.com/caiofilipini/got/irc/format"
 )
 
 const (
@@ -49,13 +51,13 @@ func (c WeatherCommand) Run(query string) []string {
 		if len(result.Weather) > 0 {
 			celsius := int(math.Floor(result.Main.Kelvin-273.15) + 0.5)
 
-			return []string{
-				fmt.Sprintf("%s, %s: %d°C, %s",
-					result.Name,
-					result.Sys.Country,
-					celsius,
-					result.Weather[0].Description),
-			}
+			var b format.Builder
+			b.Bold(fmt.Sprintf("%s, %s", result.Name, result.Sys.Country)).
+				Text(": ").
+				Color(temperatureColor(celsius), fmt.Sprintf("%d°C", celsius)).
+				Text(", " + result.Weather[0].Description)
+
+			return []string{b.String()}
 		} else {
 			return []string{
 				"Couldn't find weather information for " + query,
@@ -65,6 +67,17 @@ func (c WeatherCommand) Run(query string) []string {
 	return []string{}
 }
 
+func temperatureColor(celsius int) format.Color {
+	switch {
+	case celsius < 10:
+		return format.Blue
+	case celsius < 25:
+		return format.Green
+	default:
+		return format.Red
+	}
+}
+
 type weatherResults struct {
 	Name string `json:"name"`
 	Main struct {
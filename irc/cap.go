@@ -0,0 +1,371 @@
+package irc
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Well-known IRCv3 capabilities.
+const (
+	CapAccountTag  = "account-tag"
+	CapAwayNotify  = "away-notify"
+	CapBatch       = "batch"
+	CapEchoMessage = "echo-message"
+	CapMessageTags = "message-tags"
+	CapMultiPrefix = "multi-prefix"
+	CapSASL        = "sasl"
+	CapServerTime  = "server-time"
+
+	CapUserhostInNames = "userhost-in-names"
+)
+
+// Numeric replies that end the capability negotiation
+// when the server does not support it.
+const (
+	rplWelcome    = "001"
+	errUnknownCmd = "421"
+)
+
+// How long to wait for the negotiation to complete.
+const capWaitTimeout = 30 * time.Second
+
+// ErrNegotiationTimeout is returned when the server does not
+// complete the capability negotiation in time.
+var ErrNegotiationTimeout = errors.New("irc: timed out waiting for capability negotiation")
+
+// capabilities handles the IRCv3 capability negotiation
+// (CAP LS, REQ, ACK, NAK, NEW and DEL) and keeps track of
+// the negotiated capabilities.
+type capabilities struct {
+	sync.RWMutex
+
+	// The capabilities requested by the client.
+	wanted map[string]bool
+
+	// The capabilities advertised by the server, with
+	// their values.
+	available map[string]string
+
+	// The capabilities acknowledged by the server, with
+	// their values.
+	enabled map[string]string
+
+	// The number of requests not yet acknowledged
+	// by the server.
+	pending int
+
+	// Whether the negotiation is in progress, i.e. the
+	// client is waiting to send CAP END.
+	negotiating bool
+
+	// The SASL authentication state, if enabled.
+	sasl *sasl
+
+	// Whether the SASL authentication is in progress.
+	authenticating bool
+
+	// The result of the SASL authentication.
+	saslErr error
+
+	// Whether the SASL authentication succeeded.
+	authenticated bool
+
+	// The channel where the negotiation result is sent.
+	done chan error
+}
+
+// newCapabilities creates the negotiation state, requesting
+// the SASL capability if SASL is enabled.
+func newCapabilities(config Config) *capabilities {
+	c := &capabilities{
+		wanted:    make(map[string]bool),
+		available: make(map[string]string),
+		enabled:   make(map[string]string),
+	}
+
+	for _, name := range config.Capabilities {
+		c.wanted[name] = true
+	}
+
+	if config.SASLMechanism != "" {
+		c.sasl = newSASL(config)
+		c.wanted[CapSASL] = true
+	}
+
+	return c
+}
+
+// start begins a new negotiation by asking the server for the
+// capabilities it supports, discarding the state of any previous
+// one. It returns the channel where the result is sent.
+func (c *capabilities) start(send func(string)) chan error {
+	c.Lock()
+	c.available = make(map[string]string)
+	c.enabled = make(map[string]string)
+	c.pending = 0
+	c.authenticating = false
+	c.saslErr = nil
+	c.authenticated = false
+	c.negotiating = true
+	c.done = make(chan error, 1)
+	done := c.done
+	c.Unlock()
+
+	send("CAP LS 302")
+	return done
+}
+
+// wait blocks until the negotiation that sends its result to
+// the given channel completes, returning the SASL authentication
+// result, if enabled, until the closed channel is closed, or
+// until the replaced channel is closed, i.e. the client
+// reconnects in the meantime.
+func (c *capabilities) wait(done chan error, closed, replaced chan struct{}) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(capWaitTimeout):
+		return ErrNegotiationTimeout
+	case <-closed:
+		return ErrClosed
+	case <-replaced:
+		return errStaleSession
+	}
+}
+
+// request adds the given capabilities to the requested set.
+// If the negotiation has already finished, the ones supported
+// by the server are requested right away.
+func (c *capabilities) request(send func(string), names ...string) {
+	c.Lock()
+	for _, name := range names {
+		c.wanted[name] = true
+	}
+	var missing []string
+	if !c.negotiating {
+		missing = c.missing()
+		if len(missing) > 0 {
+			c.pending++
+		}
+	}
+	c.Unlock()
+
+	if len(missing) > 0 {
+		send("CAP REQ :" + strings.Join(missing, " "))
+	}
+}
+
+// has checks if the given capability has been enabled.
+func (c *capabilities) has(name string) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	_, found := c.enabled[name]
+	return found
+}
+
+// list returns a copy of the enabled capabilities.
+func (c *capabilities) list() map[string]string {
+	c.RLock()
+	defer c.RUnlock()
+
+	enabled := make(map[string]string, len(c.enabled))
+	for k, v := range c.enabled {
+		enabled[k] = v
+	}
+	return enabled
+}
+
+// handle reacts to the messages exchanged during the negotiation,
+// sending the responses with the given function.
+func (c *capabilities) handle(msg Message, send func(string)) {
+	switch msg.Command {
+	case "CAP":
+		c.handleCap(msg, send)
+	case rplWelcome:
+		c.finish(send, false)
+	case errUnknownCmd:
+		if strings.EqualFold(msg.Arg(1), "CAP") {
+			c.finish(send, false)
+		}
+	default:
+		if c.sasl == nil {
+			return
+		}
+		if done, err := c.sasl.handle(msg, send); done {
+			c.Lock()
+			c.authenticating = false
+			c.saslErr = err
+			c.authenticated = err == nil
+			c.Unlock()
+
+			c.finish(send, true)
+		}
+	}
+}
+
+// isAuthenticated reports whether the SASL authentication
+// succeeded (RPL_SASLSUCCESS or ERR_SASLALREADY).
+func (c *capabilities) isAuthenticated() bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.authenticated
+}
+
+// handleCap handles the CAP subcommands.
+func (c *capabilities) handleCap(msg Message, send func(string)) {
+	args := msg.Args()
+	if len(args) < 2 {
+		return
+	}
+
+	subcommand := strings.ToUpper(args[1])
+	more := len(args) > 3 && args[2] == "*"
+
+	var list string
+	if len(args) > 2 {
+		list = args[len(args)-1]
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	switch subcommand {
+	case "LS":
+		for name, value := range parseCaps(list) {
+			c.available[name] = value
+		}
+		if !more && c.negotiating {
+			c.requestMissing(send)
+		}
+	case "NEW":
+		for name, value := range parseCaps(list) {
+			c.available[name] = value
+		}
+		c.requestMissing(send)
+	case "DEL":
+		for name := range parseCaps(list) {
+			delete(c.available, name)
+			delete(c.enabled, name)
+		}
+	case "ACK":
+		c.pending--
+		for name := range parseCaps(list) {
+			if strings.HasPrefix(name, "-") {
+				delete(c.enabled, name[1:])
+				continue
+			}
+			c.enabled[name] = c.available[name]
+
+			if name == CapSASL && c.sasl != nil && c.negotiating {
+				c.authenticating = true
+				c.sasl.start(send)
+			}
+		}
+		c.endIfSettled(send)
+	case "NAK":
+		c.pending--
+		c.endIfSettled(send)
+	}
+}
+
+// requestMissing requests the wanted capabilities that are
+// supported but not enabled. If there are none left and the
+// negotiation is in progress, it is ended.
+func (c *capabilities) requestMissing(send func(string)) {
+	if missing := c.missing(); len(missing) > 0 {
+		c.pending++
+		send("CAP REQ :" + strings.Join(missing, " "))
+	}
+	c.endIfSettled(send)
+}
+
+// missing returns the wanted capabilities that are
+// supported but not enabled.
+func (c *capabilities) missing() []string {
+	var missing []string
+	for name := range c.wanted {
+		_, available := c.available[name]
+		_, enabled := c.enabled[name]
+		if available && !enabled {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// endIfSettled ends the negotiation if there are no pending
+// requests and no authentication in progress.
+func (c *capabilities) endIfSettled(send func(string)) {
+	if c.negotiating && c.pending <= 0 && !c.authenticating {
+		c.end(send, true)
+	}
+}
+
+// finish ends the negotiation; if sendEnd is true, the
+// server is notified with CAP END.
+func (c *capabilities) finish(send func(string), sendEnd bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.end(send, sendEnd)
+}
+
+// end is the unsynchronised version of finish.
+func (c *capabilities) end(send func(string), sendEnd bool) {
+	if !c.negotiating {
+		return
+	}
+	c.negotiating = false
+	c.pending = 0
+
+	if sendEnd {
+		send("CAP END")
+	}
+
+	err := c.saslErr
+	if c.sasl != nil && err == nil {
+		if _, found := c.enabled[CapSASL]; !found {
+			err = ErrSASLUnsupported
+		}
+	}
+	c.done <- err
+}
+
+// parseCaps parses a space-separated list of capabilities
+// in the name[=value] form.
+func parseCaps(list string) map[string]string {
+	caps := make(map[string]string)
+	for _, c := range strings.Fields(list) {
+		if i := strings.IndexByte(c, '='); i >= 0 {
+			caps[c[:i]] = c[i+1:]
+		} else {
+			caps[c] = ""
+		}
+	}
+	return caps
+}
+
+// Capabilities returns the capabilities negotiated with the
+// server, along with their values.
+func (irc *IRC) Capabilities() map[string]string {
+	return irc.caps.list()
+}
+
+// HasCapability checks if the given capability has been
+// negotiated with the server.
+func (irc *IRC) HasCapability(name string) bool {
+	return irc.caps.has(name)
+}
+
+// RequestCapabilities asks for the given capabilities to be
+// enabled, if supported by the server. Capabilities requested
+// before joining are negotiated during registration.
+func (irc *IRC) RequestCapabilities(names ...string) {
+	irc.caps.request(irc.queuePriority, names...)
+}
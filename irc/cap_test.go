@@ -0,0 +1,126 @@
+package irc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinNegotiatesCapabilities(t *testing.T) {
+	irc, server := newTestIRC(t, Config{
+		Channels:     []ChannelConfig{{Name: "#got"}},
+		Capabilities: []string{CapServerTime, CapAwayNotify, CapEchoMessage},
+	})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(
+		":irc.example.com CAP * LS * :multi-prefix server-time",
+		":irc.example.com CAP * LS :away-notify sts=port=6697",
+	)
+	server.expect("CAP REQ :away-notify server-time")
+
+	server.send(":irc.example.com CAP * ACK :away-notify server-time")
+	server.expect("CAP END")
+	server.welcome("gotgotgot")
+	server.expect("JOIN #got")
+
+	assert.Nil(t, <-result)
+	assert.True(t, irc.HasCapability(CapServerTime))
+	assert.True(t, irc.HasCapability(CapAwayNotify))
+	assert.False(t, irc.HasCapability(CapEchoMessage))
+	assert.Equal(t, map[string]string{"away-notify": "", "server-time": ""}, irc.Capabilities())
+}
+
+func TestJoinWithCapabilitiesRejected(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#got"}}, Capabilities: []string{CapBatch}})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com CAP * LS :batch")
+	server.expect("CAP REQ :batch")
+
+	server.send(":irc.example.com CAP * NAK :batch")
+	server.expect("CAP END")
+	server.welcome("gotgotgot")
+	server.expect("JOIN #got")
+
+	assert.Nil(t, <-result)
+	assert.False(t, irc.HasCapability(CapBatch))
+}
+
+func TestJoinWithoutCapabilitySupport(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#got"}}, Capabilities: []string{CapBatch}})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com 421 gotgotgot CAP :Unknown command")
+	server.welcome("gotgotgot")
+	server.expect("JOIN #got")
+
+	assert.Nil(t, <-result)
+	assert.Empty(t, irc.Capabilities())
+}
+
+func TestCapabilitiesNewAndDel(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#got"}}, Capabilities: []string{CapAccountTag}})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com CAP * LS :multi-prefix")
+	server.expect("CAP END")
+	server.welcome("gotgotgot")
+	server.expect("JOIN #got")
+	assert.Nil(t, <-result)
+
+	server.send(":irc.example.com CAP gotgotgot NEW :account-tag")
+	server.expect("CAP REQ :account-tag")
+
+	server.send(":irc.example.com CAP gotgotgot ACK :account-tag")
+	server.sync()
+	assert.True(t, irc.HasCapability(CapAccountTag))
+
+	server.send(":irc.example.com CAP gotgotgot DEL :account-tag")
+	server.sync()
+	assert.False(t, irc.HasCapability(CapAccountTag))
+}
+
+func TestRequestCapabilitiesAfterRegistration(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#got"}}})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com CAP * LS :echo-message")
+	server.expect("CAP END")
+	server.welcome("gotgotgot")
+	server.expect("JOIN #got")
+	assert.Nil(t, <-result)
+
+	go irc.RequestCapabilities(CapEchoMessage)
+	server.expect("CAP REQ :echo-message")
+}
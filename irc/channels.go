@@ -0,0 +1,143 @@
+package irc
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ChannelConfig holds the settings of a channel.
+type ChannelConfig struct {
+	// The channel name, including the prefix (e.g. "#got").
+	Name string
+
+	// The channel secret key, if any.
+	Key string
+
+	// The encoding of the messages sent to the channel, if it
+	// differs from the connection one (see Config.Encoding).
+	Encoding Encoding
+
+	// Whether to strip the colors from the messages sent to the
+	// channel, e.g. if it has mode +c, which blocks them.
+	NoColors bool
+}
+
+// channels keeps track of the channels the client
+// should be in.
+type channels struct {
+	sync.RWMutex
+
+	// A map where the key is the lowercased channel name,
+	// and the value is the channel configuration.
+	byName map[string]ChannelConfig
+}
+
+// newChannels creates the set with the given channels.
+func newChannels(configs []ChannelConfig) *channels {
+	c := &channels{byName: make(map[string]ChannelConfig)}
+	for _, config := range configs {
+		c.add(config)
+	}
+	return c
+}
+
+// add adds the given channel to the set.
+func (c *channels) add(config ChannelConfig) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.byName[strings.ToLower(config.Name)] = config
+}
+
+// remove removes the channel with the given name from the set.
+func (c *channels) remove(name string) {
+	c.Lock()
+	defer c.Unlock()
+
+	delete(c.byName, strings.ToLower(name))
+}
+
+// noColors reports whether colors should be stripped from
+// the messages sent to the given target.
+func (c *channels) noColors(target string) bool {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.byName[strings.ToLower(target)].NoColors
+}
+
+// list returns the configuration of all channels,
+// sorted by name.
+func (c *channels) list() []ChannelConfig {
+	c.RLock()
+	defer c.RUnlock()
+
+	configs := make([]ChannelConfig, 0, len(c.byName))
+	for _, config := range c.byName {
+		configs = append(configs, config)
+	}
+	sort.Slice(configs, func(i, j int) bool {
+		return strings.ToLower(configs[i].Name) < strings.ToLower(configs[j].Name)
+	})
+	return configs
+}
+
+// joinCommand returns the JOIN command for the given channel.
+func joinCommand(config ChannelConfig) string {
+	if config.Key != "" {
+		return fmt.Sprintf("JOIN %s %s", config.Name, config.Key)
+	}
+	return fmt.Sprintf("JOIN %s", config.Name)
+}
+
+// IsChannel checks if the given target is a channel name,
+// as opposed to a nickname.
+func IsChannel(target string) bool {
+	return target != "" && strings.ContainsRune("#&+!", rune(target[0]))
+}
+
+// ConfiguredChannels returns the names of the channels the client
+// is configured to join, sorted by name: the ones in the configuration
+// and the ones joined at runtime, minus the ones parted. The client
+// may not be in all of them (e.g. if it was kicked, or the key is
+// wrong); ChannelUsers tells the ones it's in.
+func (irc *IRC) ConfiguredChannels() []string {
+	var names []string
+	for _, config := range irc.channels.list() {
+		names = append(names, config.Name)
+	}
+	return names
+}
+
+// JoinChannel joins the given channel, using the given secret
+// key, if not empty. It is meant to be called after Join, in order
+// to join channels other than the configured ones at runtime.
+func (irc *IRC) JoinChannel(name, key string) {
+	if !irc.sending() {
+		return
+	}
+	defer irc.pending.Done()
+
+	config := ChannelConfig{Name: name, Key: key}
+
+	irc.channels.add(config)
+	irc.queue(joinCommand(config))
+}
+
+// PartChannel leaves the given channel, with an optional reason.
+func (irc *IRC) PartChannel(name, reason string) {
+	if !irc.sending() {
+		return
+	}
+	defer irc.pending.Done()
+
+	irc.channels.remove(name)
+
+	if reason != "" {
+		irc.queue(fmt.Sprintf("PART %s :%s", name, reason))
+	} else {
+		irc.queue(fmt.Sprintf("PART %s", name))
+	}
+}
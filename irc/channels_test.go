@@ -0,0 +1,70 @@
+package irc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinMultipleChannels(t *testing.T) {
+	irc, server := newTestIRC(t, Config{
+		Channels: []ChannelConfig{{Name: "#got"}, {Name: "#Beer", Key: "secret"}},
+	})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com 421 gotgotgot CAP :Unknown command")
+	server.welcome("gotgotgot")
+	server.expect("JOIN #Beer secret")
+	server.expect("JOIN #got")
+
+	assert.Nil(t, <-result)
+	assert.Equal(t, []string{"#Beer", "#got"}, irc.ConfiguredChannels())
+}
+
+func TestJoinAndPartChannelsAtRuntime(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#got"}}})
+
+	go irc.JoinChannel("#beer", "secret")
+	server.expect("JOIN #beer secret")
+	assert.Equal(t, []string{"#beer", "#got"}, irc.ConfiguredChannels())
+
+	go irc.PartChannel("#GOT", "bye")
+	server.expect("PART #GOT :bye")
+	assert.Equal(t, []string{"#beer"}, irc.ConfiguredChannels())
+
+	go irc.PartChannel("#beer", "")
+	server.expect("PART #beer")
+	assert.Empty(t, irc.ConfiguredChannels())
+}
+
+func TestSendMessagesToTarget(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+
+	go irc.SendMessages("#beer", "first", "second")
+	server.expect("PRIVMSG #beer :first")
+	server.expect("PRIVMSG #beer :second")
+}
+
+func TestNoColorsChannel(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#quiet", NoColors: true}}})
+
+	go irc.SendMessages("#Quiet", "\x02beer\x02 \x0304o'clock\x03", Action("\x0312waves"))
+	server.expect("PRIVMSG #Quiet :\x02beer\x02 o'clock")
+	server.expect("PRIVMSG #Quiet :\x01ACTION waves\x01")
+
+	go irc.SendMessages("#got", "\x0304red")
+	server.expect("PRIVMSG #got :\x0304red")
+}
+
+func TestIsChannel(t *testing.T) {
+	assert.True(t, IsChannel("#got"))
+	assert.True(t, IsChannel("&local"))
+	assert.False(t, IsChannel("marvin"))
+	assert.False(t, IsChannel(""))
+}
@@ -0,0 +1,245 @@
+package irc
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Encoding converts text between UTF-8, which the client uses
+// internally, and the charset used on the wire.
+type Encoding interface {
+	// Name returns the name of the charset.
+	Name() string
+
+	// Decode converts the given bytes to UTF-8.
+	Decode(b []byte) string
+
+	// Encode converts the given UTF-8 text to the charset.
+	// Characters the charset can't represent become "?".
+	Encode(s string) []byte
+}
+
+// The supported encodings.
+var (
+	UTF8   Encoding = utf8Encoding{}
+	Latin1 Encoding = &tableEncoding{name: "ISO-8859-1"}
+	CP1252 Encoding = &tableEncoding{name: "Windows-1252", high: cp1252High}
+)
+
+// A map where the key is a lowercased charset name or alias,
+// and the value is the encoding.
+var encodings = map[string]Encoding{
+	"utf-8":        UTF8,
+	"utf8":         UTF8,
+	"iso-8859-1":   Latin1,
+	"latin1":       Latin1,
+	"cp1252":       CP1252,
+	"windows-1252": CP1252,
+}
+
+// LookupEncoding returns the encoding with the given name
+// (e.g. "UTF-8", "ISO-8859-1", "latin1" or "CP1252").
+func LookupEncoding(name string) (Encoding, error) {
+	if enc, ok := encodings[strings.ToLower(name)]; ok {
+		return enc, nil
+	}
+	return nil, fmt.Errorf("irc: unsupported encoding %q", name)
+}
+
+// utf8Encoding is the UTF-8 encoding, where converting is a no-op
+// except for invalid sequences, which become U+FFFD.
+type utf8Encoding struct{}
+
+func (utf8Encoding) Name() string { return "UTF-8" }
+
+func (utf8Encoding) Decode(b []byte) string {
+	return strings.ToValidUTF8(string(b), "�")
+}
+
+func (utf8Encoding) Encode(s string) []byte {
+	return []byte(s)
+}
+
+// tableEncoding is a single-byte encoding which matches
+// ISO-8859-1 except for the characters in 0x80-0x9F.
+type tableEncoding struct {
+	// The charset name.
+	name string
+
+	// The characters in 0x80-0x9F, if they differ from
+	// ISO-8859-1 (i.e. C1 control codes); zero for the
+	// undefined ones.
+	high *[32]rune
+
+	// A map where the key is a character in high, and the
+	// value is its byte, built on first use.
+	reverse     map[rune]byte
+	reverseOnce sync.Once
+}
+
+// The characters in 0x80-0x9F in Windows-1252.
+var cp1252High = &[32]rune{
+	'€', 0, '‚', 'ƒ', '„', '…', '†', '‡', 'ˆ', '‰', 'Š', '‹', 'Œ', 0, 'Ž', 0,
+	0, '‘', '’', '“', '”', '•', '–', '—', '˜', '™', 'š', '›', 'œ', 0, 'ž', 'Ÿ',
+}
+
+func (e *tableEncoding) Name() string { return e.name }
+
+func (e *tableEncoding) Decode(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		r := rune(c)
+		if e.high != nil && c >= 0x80 && c < 0xa0 {
+			if r = e.high[c-0x80]; r == 0 {
+				r = utf8.RuneError
+			}
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func (e *tableEncoding) Encode(s string) []byte {
+	e.reverseOnce.Do(func() {
+		e.reverse = make(map[rune]byte)
+		if e.high != nil {
+			for i, r := range e.high {
+				if r != 0 {
+					e.reverse[r] = byte(0x80 + i)
+				}
+			}
+		}
+	})
+
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		switch c, ok := e.reverse[r]; {
+		case ok:
+			b = append(b, c)
+		case r < 0x80 || (r >= 0xa0 && r <= 0xff) || (r < 0xa0 && e.high == nil):
+			b = append(b, byte(r))
+		default:
+			b = append(b, '?')
+		}
+	}
+	return b
+}
+
+// charsets keeps track of the encodings used on the wire.
+type charsets struct {
+	sync.RWMutex
+
+	// The encoding of the connection, used unless the
+	// channel a message is sent to has its own.
+	conn Encoding
+
+	// The encoding used to decode incoming messages that are
+	// not valid UTF-8, if neither the connection nor the
+	// channel have a legacy encoding.
+	fallback Encoding
+
+	// A map where the key is the lowercased channel name,
+	// and the value is the channel encoding.
+	channels map[string]Encoding
+}
+
+// newCharsets creates the charsets with the configured encodings.
+func newCharsets(config Config) *charsets {
+	c := &charsets{
+		conn:     config.Encoding,
+		fallback: config.FallbackEncoding,
+		channels: make(map[string]Encoding),
+	}
+	if c.conn == nil {
+		c.conn = UTF8
+	}
+	if c.fallback == nil {
+		c.fallback = CP1252
+	}
+	for _, channel := range config.Channels {
+		if channel.Encoding != nil {
+			c.channels[strings.ToLower(channel.Name)] = channel.Encoding
+		}
+	}
+	return c
+}
+
+// encoding returns the encoding used for messages sent
+// to the given target.
+func (c *charsets) encoding(target string) Encoding {
+	c.RLock()
+	defer c.RUnlock()
+
+	if enc, ok := c.channels[strings.ToLower(target)]; ok {
+		return enc
+	}
+	return c.conn
+}
+
+// decode converts an incoming line to UTF-8. Valid UTF-8 is left
+// as is, since clients in legacy channels often use it; otherwise
+// the line is decoded with the encoding of the channel it was sent
+// to, if any, or of the connection, falling back to the fallback
+// encoding if both are UTF-8.
+func (c *charsets) decode(line string) string {
+	if utf8.ValidString(line) {
+		return line
+	}
+
+	var target string
+	if msg, err := ParseMessage(line); err == nil {
+		target = msg.Arg(0)
+	}
+
+	enc := c.encoding(target)
+	if enc == UTF8 {
+		c.RLock()
+		enc = c.fallback
+		c.RUnlock()
+	}
+	return enc.Decode([]byte(line))
+}
+
+// encode converts an outgoing message from UTF-8 to the encoding
+// of the channel it's sent to, if any, or of the connection.
+func (c *charsets) encode(msg string) []byte {
+	var target string
+	if fields := strings.SplitN(msg, " ", 3); len(fields) > 1 {
+		target = fields[1]
+	}
+	return c.encoding(target).Encode(msg)
+}
+
+// textConn is implemented by connections that can only carry
+// UTF-8 text (e.g. WebSockets with the text subprotocol), over
+// which messages are sent as is, whatever the encodings.
+type textConn interface {
+	// textOnly checks if the connection only carries UTF-8.
+	textOnly() bool
+}
+
+// encodeFor converts an outgoing message to the encoding used
+// for it on the given connection (see encode).
+func (c *charsets) encodeFor(conn net.Conn, msg string) []byte {
+	if t, ok := conn.(textConn); ok && t.textOnly() {
+		return []byte(msg)
+	}
+	return c.encode(msg)
+}
+
+// SetChannelEncoding sets the encoding used for the messages
+// sent to and received from the given channel. If nil, the
+// connection encoding is used.
+func (irc *IRC) SetChannelEncoding(channel string, enc Encoding) {
+	irc.charsets.Lock()
+	defer irc.charsets.Unlock()
+
+	if enc == nil {
+		delete(irc.charsets.channels, strings.ToLower(channel))
+		return
+	}
+	irc.charsets.channels[strings.ToLower(channel)] = enc
+}
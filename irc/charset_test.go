@@ -0,0 +1,80 @@
+package irc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupEncoding(t *testing.T) {
+	enc, err := LookupEncoding("Latin1")
+	assert.Nil(t, err)
+	assert.Equal(t, Latin1, enc)
+
+	enc, err = LookupEncoding("windows-1252")
+	assert.Nil(t, err)
+	assert.Equal(t, CP1252, enc)
+
+	_, err = LookupEncoding("KOI8-R")
+	assert.NotNil(t, err)
+}
+
+func TestLatin1(t *testing.T) {
+	assert.Equal(t, "café ¿qué?", Latin1.Decode([]byte("caf\xe9 \xbfqu\xe9?")))
+	assert.Equal(t, []byte("caf\xe9 \x80?"), Latin1.Encode("café \u0080€"))
+}
+
+func TestCP1252(t *testing.T) {
+	assert.Equal(t, "“café” €5 �", CP1252.Decode([]byte("\x93caf\xe9\x94 \x805 \x81")))
+	assert.Equal(t, []byte("\x93caf\xe9\x94 \x805 ?"), CP1252.Encode("“café” €5 ☃"))
+}
+
+func TestDecodeValidUTF8(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#legacy", Encoding: Latin1}}})
+	defer irc.Close()
+
+	messages := make(chan Message, 1)
+	irc.Subscribe(func(msg Message) bool { return msg.Command == "PRIVMSG" }, messages)
+
+	server.send(":marvin!~marvin@hhgg.org PRIVMSG #legacy :café")
+	assert.Equal(t, "café", (<-messages).Trailing)
+}
+
+func TestDecodeFallback(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#legacy", Encoding: Latin1}}})
+	defer irc.Close()
+
+	messages := make(chan Message, 2)
+	irc.Subscribe(func(msg Message) bool { return msg.Command == "PRIVMSG" }, messages)
+
+	server.send(
+		":marvin!~marvin@hhgg.org PRIVMSG #got :\x93caf\xe9\x94",
+		":marvin!~marvin@hhgg.org PRIVMSG #legacy :\x93caf\xe9\x94",
+	)
+	assert.Equal(t, "“café”", (<-messages).Trailing)
+	assert.Equal(t, "\u0093café\u0094", (<-messages).Trailing)
+}
+
+func TestEncodeChannel(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#legacy", Encoding: CP1252}}})
+	defer irc.Close()
+
+	go irc.SendMessages("#Legacy", "“café”")
+	server.expect("PRIVMSG #Legacy :\x93caf\xe9\x94")
+
+	go irc.SendMessages("#got", "“café”")
+	server.expect("PRIVMSG #got :“café”")
+}
+
+func TestSetChannelEncoding(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Encoding: Latin1})
+	defer irc.Close()
+
+	irc.SetChannelEncoding("#got", UTF8)
+	go irc.SendMessages("#got", "café")
+	server.expect("PRIVMSG #got :café")
+
+	irc.SetChannelEncoding("#got", nil)
+	go irc.SendMessages("#got", "café")
+	server.expect("PRIVMSG #got :caf\xe9")
+}
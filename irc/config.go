@@ -0,0 +1,167 @@
+package irc
+
+import (
+	"net"
+	"strconv"
+	"time"
+)
+
+// Config holds the settings used to connect to an IRC server.
+type Config struct {
+	// The IRC server to connect to.
+	Server string
+
+	// The server port to connect to.
+	Port int
+
+	// The Dialer used to connect to the server. If nil,
+	// connections are made over TCP. If TLS is enabled, it's
+	// established on top of the connections the Dialer makes.
+	Dialer Dialer
+
+	// The initial delay between reconnection attempts, which
+	// doubles after each failed attempt. Defaults to
+	// DefaultReconnectDelay.
+	ReconnectDelay time.Duration
+
+	// The maximum delay between reconnection attempts.
+	// Defaults to DefaultReconnectMaxDelay.
+	ReconnectMaxDelay time.Duration
+
+	// The maximum number of consecutive connection attempts
+	// before giving up. If zero, it retries forever.
+	ReconnectMaxAttempts int
+
+	// Whether to stop when the connection is lost, reporting it
+	// on the errors channel, instead of reconnecting automatically.
+	DisableReconnect bool
+
+	// How often to PING the server once registered, in order to
+	// measure the lag. Defaults to DefaultPingInterval; if negative,
+	// the server is not pinged and dead connections go unnoticed.
+	PingInterval time.Duration
+
+	// How long to wait for the server to reply to a PING before
+	// dropping the connection and reconnecting. It's checked on
+	// every PingInterval. Defaults to DefaultPingTimeout.
+	PingTimeout time.Duration
+
+	// The number of messages that can be sent in a burst,
+	// before flood control kicks in. Defaults to
+	// DefaultFloodBurst.
+	FloodBurst int
+
+	// The interval between messages once the burst is used up.
+	// Defaults to DefaultFloodInterval; if negative, flood control
+	// is disabled.
+	FloodInterval time.Duration
+
+	// The maximum number of lines a single message can be split
+	// into when it exceeds the protocol length limit; the text
+	// is truncated beyond that. If zero, there is no limit.
+	MaxLines int
+
+	// The number of events that can be waiting for each event
+	// handler before further events are dropped. Defaults to
+	// DefaultEventQueueSize.
+	EventQueueSize int
+
+	// The reason sent with QUIT when shutting down.
+	// Defaults to DefaultQuitMessage.
+	QuitMessage string
+
+	// The reply to CTCP VERSION requests. Defaults to
+	// DefaultVersion.
+	Version string
+
+	// The reply to CTCP SOURCE requests. Defaults to
+	// DefaultSource.
+	Source string
+
+	// The number of CTCP requests answered in a burst, before
+	// CTCP flood control kicks in. Defaults to
+	// DefaultCTCPFloodBurst.
+	CTCPFloodBurst int
+
+	// The minimum interval between CTCP replies once the burst
+	// is used up; requests received in between are ignored.
+	// Defaults to DefaultCTCPFloodInterval; if negative, CTCP
+	// flood control is disabled.
+	CTCPFloodInterval time.Duration
+
+	// The nicknames to try, in order, if the primary one is
+	// unavailable. Once these are exhausted, underscores are
+	// appended to the primary nickname.
+	AltNicks []string
+
+	// How often to try to reclaim the primary nickname while
+	// using a fallback one. Defaults to DefaultNickReclaimInterval;
+	// if negative, it is only reclaimed when seen to become free.
+	NickReclaimInterval time.Duration
+
+	// The password used to identify with NickServ. Identifying is
+	// skipped if the client is already authenticated via SASL.
+	NickServPassword string
+
+	// How to recover the primary nickname from NickServ when it's
+	// in use: "GHOST" or "REGAIN". If empty, the nickname is only
+	// reclaimed once it becomes free.
+	NickServRecover string
+
+	// The IRC channels to join.
+	Channels []ChannelConfig
+
+	// The encoding of the messages sent and received, unless the
+	// channel they're sent to has its own. Defaults to UTF8.
+	Encoding Encoding
+
+	// The encoding used to decode incoming messages that are
+	// not valid UTF-8, when neither the connection nor the
+	// channel have another encoding. Defaults to CP1252.
+	FallbackEncoding Encoding
+
+	// The server password, sent with PASS when registering.
+	Password string
+
+	// Whether the connection should use TLS.
+	TLS bool
+
+	// The path to a PEM file containing the CA certificates used
+	// to verify the server certificate. If empty, the system
+	// certificate pool is used.
+	TLSCAFile string
+
+	// The server name sent via SNI and used to verify the server
+	// certificate. If empty, Server is used.
+	TLSServerName string
+
+	// Whether to skip the server certificate verification.
+	// Only meant to be used in test networks.
+	TLSInsecure bool
+
+	// The paths to the PEM encoded client certificate and key,
+	// used for CertFP authentication.
+	TLSCertFile string
+	TLSKeyFile  string
+
+	// The IRCv3 capabilities to request during registration,
+	// if supported by the server.
+	Capabilities []string
+
+	// The SASL mechanism used to authenticate ("PLAIN" or
+	// "EXTERNAL"). If empty, SASL authentication is disabled.
+	SASLMechanism string
+
+	// The account credentials used by the PLAIN mechanism.
+	SASLUser     string
+	SASLPassword string
+
+	// Whether to give up connecting if the SASL authentication
+	// fails. Otherwise, the bot proceeds unauthenticated.
+	SASLAbortOnFailure bool
+}
+
+// address returns the server address in the host:port form.
+func (c Config) address() string {
+	return net.JoinHostPort(c.Server, strconv.Itoa(c.Port))
+}
@@ -0,0 +1,164 @@
+package irc
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// The delimiter that marks the beginning and end
+// of CTCP messages.
+const ctcpDelim = "\x01"
+
+// Default CTCP settings.
+const (
+	DefaultVersion = "got, the IRC bot – https://github.com/caiofilipini/got"
+	DefaultSource  = "https://github.com/caiofilipini/got"
+
+	// Requests beyond the burst are answered at most once
+	// every interval; the others are ignored.
+	DefaultCTCPFloodBurst    = 3
+	DefaultCTCPFloodInterval = 10 * time.Second
+)
+
+// The CTCP requests the client replies to.
+var ctcpQueries = []string{"CLIENTINFO", "PING", "SOURCE", "TIME", "VERSION"}
+
+// CTCP is a Client-To-Client Protocol message, embedded in the
+// text of a PRIVMSG (request) or a NOTICE (reply).
+type CTCP struct {
+	// The CTCP command (e.g. "VERSION" or "ACTION").
+	Command string
+
+	// The parameters of the command, if any.
+	Params string
+}
+
+// ParseCTCP parses the given message text as a CTCP message,
+// and reports whether it is one.
+func ParseCTCP(text string) (CTCP, bool) {
+	if !strings.HasPrefix(text, ctcpDelim) {
+		return CTCP{}, false
+	}
+
+	// The closing delimiter is optional.
+	text = strings.TrimSuffix(text[1:], ctcpDelim)
+	if text == "" {
+		return CTCP{}, false
+	}
+
+	c := CTCP{Command: text}
+	if i := strings.IndexByte(text, ' '); i >= 0 {
+		c.Command, c.Params = text[:i], text[i+1:]
+	}
+	c.Command = strings.ToUpper(c.Command)
+
+	return c, true
+}
+
+// String returns the CTCP message as embedded
+// in the message text.
+func (c CTCP) String() string {
+	if c.Params == "" {
+		return ctcpDelim + c.Command + ctcpDelim
+	}
+	return ctcpDelim + c.Command + " " + c.Params + ctcpDelim
+}
+
+// CTCP returns the CTCP message embedded in a PRIVMSG or
+// NOTICE, and reports whether the message contains one.
+func (m Message) CTCP() (CTCP, bool) {
+	if m.Command != "PRIVMSG" && m.Command != "NOTICE" {
+		return CTCP{}, false
+	}
+	return ParseCTCP(m.Trailing)
+}
+
+// Action returns the given text as an ACTION (i.e. "/me"), which
+// can be sent with SendMessages like any other message.
+func Action(text string) string {
+	return CTCP{"ACTION", text}.String()
+}
+
+// SendAction sends the given text as an ACTION (i.e. "/me")
+// to the given target, which can be either a channel or
+// a nickname.
+func (irc *IRC) SendAction(target, text string) {
+	if !irc.sending() {
+		return
+	}
+	defer irc.pending.Done()
+
+	irc.sendCTCP("PRIVMSG", target, "ACTION", text)
+}
+
+// sendCTCP sends the given text to the target as a CTCP message,
+// split into as many messages as needed to fit the protocol
+// length limit.
+func (irc *IRC) sendCTCP(command, target, ctcp, text string) {
+	overhead := len(CTCP{ctcp, " "}.String()) - 1
+
+	for _, line := range irc.splitFor(command, target, irc.colorsFor(target, text), overhead) {
+		irc.queue(command + " " + target + " :" + CTCP{ctcp, line}.String())
+	}
+}
+
+// handleCTCP replies to the CTCP requests sent to the client,
+// ignoring the ones that exceed the CTCP flood control limits.
+func (irc *IRC) handleCTCP(msg Message) {
+	if msg.Command != "PRIVMSG" || strings.EqualFold(msg.Prefix.Nick, irc.Nick()) {
+		return
+	}
+
+	req, ok := msg.CTCP()
+	if !ok {
+		return
+	}
+
+	var reply string
+	switch req.Command {
+	case "CLIENTINFO":
+		reply = strings.Join(append([]string{"ACTION"}, ctcpQueries...), " ")
+	case "PING":
+		reply = req.Params
+	case "SOURCE":
+		reply = irc.config.Source
+		if reply == "" {
+			reply = DefaultSource
+		}
+	case "TIME":
+		reply = time.Now().Format(time.RFC1123Z)
+	case "VERSION":
+		reply = irc.config.Version
+		if reply == "" {
+			reply = DefaultVersion
+		}
+	default:
+		return
+	}
+
+	if !irc.ctcpFlood.take() {
+		log.Printf("[IRC] Ignoring CTCP %s from %s: too many requests\n", req.Command, msg.Prefix.Nick)
+		return
+	}
+
+	irc.reply("NOTICE " + msg.Prefix.Nick + " :" + CTCP{req.Command, reply}.String())
+}
+
+// newCTCPBucket creates the bucket used to limit the rate of
+// CTCP replies. If the configured interval is negative, CTCP
+// flood control is disabled and nil is returned.
+func newCTCPBucket(config Config) *tokenBucket {
+	burst, interval := config.CTCPFloodBurst, config.CTCPFloodInterval
+	if interval < 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = DefaultCTCPFloodBurst
+	}
+	if interval == 0 {
+		interval = DefaultCTCPFloodInterval
+	}
+
+	return newBucket(burst, interval)
+}
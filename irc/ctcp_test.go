@@ -0,0 +1,157 @@
+package irc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCTCP(t *testing.T) {
+	c, ok := ParseCTCP("\x01ping 1234 5678\x01")
+	assert.True(t, ok)
+	assert.Equal(t, CTCP{"PING", "1234 5678"}, c)
+
+	c, ok = ParseCTCP("\x01VERSION")
+	assert.True(t, ok)
+	assert.Equal(t, CTCP{"VERSION", ""}, c)
+
+	_, ok = ParseCTCP("VERSION")
+	assert.False(t, ok)
+
+	_, ok = ParseCTCP("\x01\x01")
+	assert.False(t, ok)
+}
+
+func TestMessageCTCP(t *testing.T) {
+	msg, _ := ParseMessage(":marvin!~marvin@hhgg.org PRIVMSG #got :\x01ACTION sighs\x01")
+
+	c, ok := msg.CTCP()
+	assert.True(t, ok)
+	assert.Equal(t, CTCP{"ACTION", "sighs"}, c)
+
+	msg, _ = ParseMessage(":marvin!~marvin@hhgg.org TOPIC #got :\x01ACTION sighs\x01")
+	_, ok = msg.CTCP()
+	assert.False(t, ok)
+}
+
+func TestCTCPReplies(t *testing.T) {
+	_, server := newTestIRC(t, Config{Version: "got 1.0"})
+
+	server.send(":marvin!~marvin@hhgg.org PRIVMSG got :\x01VERSION\x01")
+	server.expect("NOTICE marvin :\x01VERSION got 1.0\x01")
+
+	server.send(":marvin!~marvin@hhgg.org PRIVMSG got :\x01PING 1234567890\x01")
+	server.expect("NOTICE marvin :\x01PING 1234567890\x01")
+
+	server.send(":marvin!~marvin@hhgg.org PRIVMSG got :\x01SOURCE\x01")
+	server.expect("NOTICE marvin :\x01SOURCE " + DefaultSource + "\x01")
+}
+
+func TestCTCPTime(t *testing.T) {
+	_, server := newTestIRC(t, Config{})
+
+	server.send(":marvin!~marvin@hhgg.org PRIVMSG got :\x01TIME\x01")
+	server.conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, _ := server.reader.ReadString('\n')
+
+	reply, ok := ParseCTCP(strings.TrimPrefix(strings.TrimRight(line, "\r\n"), "NOTICE marvin :"))
+	assert.True(t, ok)
+	assert.Equal(t, "TIME", reply.Command)
+
+	_, err := time.Parse(time.RFC1123Z, reply.Params)
+	assert.Nil(t, err)
+}
+
+func TestCTCPIgnoresUnknownRequestsAndReplies(t *testing.T) {
+	_, server := newTestIRC(t, Config{})
+
+	server.send(
+		":marvin!~marvin@hhgg.org PRIVMSG got :\x01FINGER\x01",
+		":marvin!~marvin@hhgg.org NOTICE got :\x01VERSION marvin 42\x01",
+		":marvin!~marvin@hhgg.org PRIVMSG #got :\x01ACTION sighs\x01",
+	)
+	server.sync()
+}
+
+func TestCTCPFloodProtection(t *testing.T) {
+	_, server := newTestIRC(t, Config{CTCPFloodBurst: 2, CTCPFloodInterval: time.Hour})
+
+	for i := 0; i < 2; i++ {
+		server.send(":marvin!~marvin@hhgg.org PRIVMSG got :\x01PING 1\x01")
+		server.expect("NOTICE marvin :\x01PING 1\x01")
+	}
+
+	server.send(":marvin!~marvin@hhgg.org PRIVMSG got :\x01PING 2\x01")
+	server.sync()
+}
+
+func TestCTCPRepliesDoNotBlockReader(t *testing.T) {
+	irc, server := newTestIRC(t, Config{FloodBurst: 1, FloodInterval: time.Hour})
+
+	go irc.SendMessages("#got", "first", "second")
+	server.expect("PRIVMSG #got :first")
+
+	server.send(
+		":marvin!~marvin@hhgg.org PRIVMSG got :\x01PING 1\x01",
+		":marvin!~marvin@hhgg.org PRIVMSG got :\x01PING 2\x01",
+	)
+	server.sync()
+}
+
+func TestCTCPRepliesAreBounded(t *testing.T) {
+	_, server := newTestIRC(t, Config{FloodBurst: 1, FloodInterval: 10 * time.Millisecond, CTCPFloodInterval: -1})
+
+	for i := 0; i < 100; i++ {
+		server.send(":marvin!~marvin@hhgg.org PRIVMSG got :\x01PING 1\x01")
+	}
+
+	// The replies that don't fit in the queue are dropped.
+	replies := 0
+	for {
+		server.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		line, err := server.reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		if strings.HasPrefix(line, "NOTICE marvin ") {
+			replies++
+		}
+	}
+	assert.True(t, replies >= maxPendingReplies)
+	assert.True(t, replies < 50, "%d replies", replies)
+}
+
+func TestCTCPRepliesInOrder(t *testing.T) {
+	_, server := newTestIRC(t, Config{CTCPFloodInterval: -1})
+
+	for i := 0; i < 5; i++ {
+		server.send(fmt.Sprintf(":marvin!~marvin@hhgg.org PRIVMSG got :\x01PING %d\x01", i))
+	}
+	for i := 0; i < 5; i++ {
+		server.expect(fmt.Sprintf("NOTICE marvin :\x01PING %d\x01", i))
+	}
+}
+
+func TestSendAction(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+
+	go irc.SendAction("#got", "sighs")
+	server.expect("PRIVMSG #got :\x01ACTION sighs\x01")
+
+	go irc.SendMessages("#got", "hello", Action("waves"))
+	server.expect("PRIVMSG #got :hello")
+	server.expect("PRIVMSG #got :\x01ACTION waves\x01")
+}
+
+func TestSendActionSplitsLongText(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+	irc.self = Prefix{"got", "~got", "example.com"}
+
+	go irc.SendAction("#got", strings.Repeat("a", 300)+" "+strings.Repeat("b", 300))
+
+	server.expect("PRIVMSG #got :\x01ACTION " + strings.Repeat("a", 300) + "\x01")
+	server.expect("PRIVMSG #got :\x01ACTION " + strings.Repeat("b", 300) + "\x01")
+}
@@ -0,0 +1,393 @@
+package irc
+
+import (
+	"log"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+// DefaultEventQueueSize is the default number of events that can be
+// waiting for each handler before further events are dropped.
+const DefaultEventQueueSize = 64
+
+// MessageEvent is a PRIVMSG or a NOTICE, including ACTIONs
+// (i.e. "/me"), but excluding other CTCP messages.
+type MessageEvent struct {
+	// The message as received.
+	Message Message
+
+	// Who sent the message.
+	Sender Prefix
+
+	// The channel or nickname the message was sent to.
+	Target string
+
+	// The message text; for an ACTION, the action text.
+	Text string
+
+	// Whether the message is an ACTION.
+	Action bool
+
+	// Whether the message was sent to the client rather
+	// than to a channel.
+	Private bool
+}
+
+// JoinEvent is a user joining a channel.
+type JoinEvent struct {
+	// The message as received.
+	Message Message
+
+	// Who joined the channel.
+	User Prefix
+
+	// The channel joined.
+	Channel string
+}
+
+// PartEvent is a user leaving a channel.
+type PartEvent struct {
+	// The message as received.
+	Message Message
+
+	// Who left the channel.
+	User Prefix
+
+	// The channel left.
+	Channel string
+
+	// The reason given, if any.
+	Reason string
+}
+
+// QuitEvent is a user disconnecting from the server.
+type QuitEvent struct {
+	// The message as received.
+	Message Message
+
+	// Who disconnected.
+	User Prefix
+
+	// The reason given, if any.
+	Reason string
+}
+
+// NickEvent is a user changing nicknames.
+type NickEvent struct {
+	// The message as received.
+	Message Message
+
+	// The user, with the old nickname.
+	User Prefix
+
+	// The new nickname.
+	Nick string
+}
+
+// KickEvent is a user being kicked from a channel.
+type KickEvent struct {
+	// The message as received.
+	Message Message
+
+	// Who kicked the user.
+	By Prefix
+
+	// The channel the user was kicked from.
+	Channel string
+
+	// The nickname of the user kicked.
+	Nick string
+
+	// The reason given, if any.
+	Reason string
+}
+
+// ModeEvent is a change of the modes of a channel or a user.
+type ModeEvent struct {
+	// The message as received.
+	Message Message
+
+	// Who changed the modes.
+	By Prefix
+
+	// The channel or nickname whose modes changed.
+	Target string
+
+	// The mode changes (e.g. "+o-v").
+	Modes string
+
+	// The parameters of the mode changes, if any.
+	Params []string
+}
+
+// TopicEvent is a change of the topic of a channel.
+type TopicEvent struct {
+	// The message as received.
+	Message Message
+
+	// Who changed the topic.
+	By Prefix
+
+	// The channel whose topic changed.
+	Channel string
+
+	// The new topic; empty if it was cleared.
+	Topic string
+}
+
+// InviteEvent is an invitation to join a channel.
+type InviteEvent struct {
+	// The message as received.
+	Message Message
+
+	// Who sent the invitation.
+	By Prefix
+
+	// The nickname of the user invited.
+	Nick string
+
+	// The channel the user was invited to.
+	Channel string
+}
+
+// EventHandler is a registered event handler. Each handler
+// receives its events in order, in its own goroutine, so that
+// a slow handler doesn't hold up the others or the client; if
+// it falls too far behind, further events are dropped.
+type EventHandler struct {
+	// The command of the messages handled.
+	command string
+
+	// The function that handles the messages.
+	handle func(Message)
+
+	// The messages waiting to be handled.
+	queue chan Message
+
+	// Closed when the handler is removed.
+	done chan struct{}
+
+	// Makes sure the handler is removed only once.
+	removeOnce sync.Once
+
+	// The registry the handler belongs to.
+	events *events
+}
+
+// Remove stops the handler from receiving further events.
+// The event being handled, if any, is not interrupted.
+func (h *EventHandler) Remove() {
+	h.removeOnce.Do(func() {
+		h.events.remove(h)
+		close(h.done)
+	})
+}
+
+// run handles the queued messages until the handler is removed.
+func (h *EventHandler) run() {
+	for {
+		select {
+		case msg := <-h.queue:
+			h.safely(msg)
+		case <-h.done:
+			return
+		}
+	}
+}
+
+// safely handles the given message, recovering from panics,
+// which are logged.
+func (h *EventHandler) safely(msg Message) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("[IRC] Event handler for %s panicked: %v\n%s", h.command, err, debug.Stack())
+		}
+	}()
+
+	h.handle(msg)
+}
+
+// events holds the event handlers.
+type events struct {
+	sync.RWMutex
+
+	// The number of events that can be waiting for
+	// each handler.
+	queueSize int
+
+	// A map where the key is a command, and the value is
+	// the handlers of the messages with that command.
+	handlers map[string][]*EventHandler
+}
+
+// newEvents creates the event handler registry.
+func newEvents(config Config) *events {
+	size := config.EventQueueSize
+	if size <= 0 {
+		size = DefaultEventQueueSize
+	}
+	return &events{queueSize: size, handlers: make(map[string][]*EventHandler)}
+}
+
+// add registers a handler for the messages with the
+// given command, in any case, and starts it.
+func (e *events) add(command string, handle func(Message)) *EventHandler {
+	command = strings.ToUpper(command)
+	h := &EventHandler{
+		command: command,
+		handle:  handle,
+		queue:   make(chan Message, e.queueSize),
+		done:    make(chan struct{}),
+		events:  e,
+	}
+
+	e.Lock()
+	e.handlers[command] = append(e.handlers[command], h)
+	e.Unlock()
+
+	go h.run()
+	return h
+}
+
+// remove unregisters the given handler.
+func (e *events) remove(h *EventHandler) {
+	e.Lock()
+	defer e.Unlock()
+
+	handlers := e.handlers[h.command]
+	for i, other := range handlers {
+		if other == h {
+			e.handlers[h.command] = append(handlers[:i:i], handlers[i+1:]...)
+			return
+		}
+	}
+}
+
+// dispatch queues the given message for the handlers of its
+// command, without waiting for them. Handlers whose queue is
+// full miss the message.
+func (e *events) dispatch(msg Message) {
+	e.RLock()
+	defer e.RUnlock()
+
+	for _, h := range e.handlers[msg.Command] {
+		select {
+		case h.queue <- msg:
+		default:
+			log.Printf("[IRC] Event handler for %s is too slow, dropping event\n", msg.Command)
+		}
+	}
+}
+
+// removeAll removes all the handlers.
+func (e *events) removeAll() {
+	e.RLock()
+	var all []*EventHandler
+	for _, handlers := range e.handlers {
+		all = append(all, handlers...)
+	}
+	e.RUnlock()
+
+	for _, h := range all {
+		h.Remove()
+	}
+}
+
+// OnMessage registers a handler for the messages with the given
+// command (e.g. "PRIVMSG" or "001"), in any case, for which
+// there's no typed event.
+func (irc *IRC) OnMessage(command string, handle func(Message)) *EventHandler {
+	return irc.events.add(command, handle)
+}
+
+// OnPrivmsg registers a handler for PRIVMSG events.
+func (irc *IRC) OnPrivmsg(handle func(MessageEvent)) *EventHandler {
+	return irc.onText("PRIVMSG", handle)
+}
+
+// OnNotice registers a handler for NOTICE events.
+func (irc *IRC) OnNotice(handle func(MessageEvent)) *EventHandler {
+	return irc.onText("NOTICE", handle)
+}
+
+// onText registers a handler for PRIVMSG or NOTICE events.
+func (irc *IRC) onText(command string, handle func(MessageEvent)) *EventHandler {
+	return irc.events.add(command, func(msg Message) {
+		e := MessageEvent{
+			Message: msg,
+			Sender:  msg.Prefix,
+			Target:  msg.Arg(0),
+			Text:    msg.Trailing,
+			Private: !IsChannel(msg.Arg(0)),
+		}
+		if ctcp, ok := msg.CTCP(); ok {
+			if ctcp.Command != "ACTION" {
+				return
+			}
+			e.Text, e.Action = ctcp.Params, true
+		}
+		handle(e)
+	})
+}
+
+// OnJoin registers a handler for JOIN events.
+func (irc *IRC) OnJoin(handle func(JoinEvent)) *EventHandler {
+	return irc.events.add("JOIN", func(msg Message) {
+		handle(JoinEvent{msg, msg.Prefix, msg.Arg(0)})
+	})
+}
+
+// OnPart registers a handler for PART events.
+func (irc *IRC) OnPart(handle func(PartEvent)) *EventHandler {
+	return irc.events.add("PART", func(msg Message) {
+		handle(PartEvent{msg, msg.Prefix, msg.Arg(0), msg.Arg(1)})
+	})
+}
+
+// OnQuit registers a handler for QUIT events.
+func (irc *IRC) OnQuit(handle func(QuitEvent)) *EventHandler {
+	return irc.events.add("QUIT", func(msg Message) {
+		handle(QuitEvent{msg, msg.Prefix, msg.Arg(0)})
+	})
+}
+
+// OnNick registers a handler for NICK events.
+func (irc *IRC) OnNick(handle func(NickEvent)) *EventHandler {
+	return irc.events.add("NICK", func(msg Message) {
+		handle(NickEvent{msg, msg.Prefix, msg.Arg(0)})
+	})
+}
+
+// OnKick registers a handler for KICK events.
+func (irc *IRC) OnKick(handle func(KickEvent)) *EventHandler {
+	return irc.events.add("KICK", func(msg Message) {
+		handle(KickEvent{msg, msg.Prefix, msg.Arg(0), msg.Arg(1), msg.Arg(2)})
+	})
+}
+
+// OnMode registers a handler for MODE events.
+func (irc *IRC) OnMode(handle func(ModeEvent)) *EventHandler {
+	return irc.events.add("MODE", func(msg Message) {
+		args := msg.Args()
+		e := ModeEvent{Message: msg, By: msg.Prefix, Target: msg.Arg(0), Modes: msg.Arg(1)}
+		if len(args) > 2 {
+			e.Params = args[2:]
+		}
+		handle(e)
+	})
+}
+
+// OnTopic registers a handler for TOPIC events.
+func (irc *IRC) OnTopic(handle func(TopicEvent)) *EventHandler {
+	return irc.events.add("TOPIC", func(msg Message) {
+		handle(TopicEvent{msg, msg.Prefix, msg.Arg(0), msg.Arg(1)})
+	})
+}
+
+// OnInvite registers a handler for INVITE events.
+func (irc *IRC) OnInvite(handle func(InviteEvent)) *EventHandler {
+	return irc.events.add("INVITE", func(msg Message) {
+		handle(InviteEvent{msg, msg.Prefix, msg.Arg(0), msg.Arg(1)})
+	})
+}
@@ -0,0 +1,188 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// receive waits for an event sent to the given channel.
+func receive(t *testing.T, events chan interface{}) interface{} {
+	select {
+	case e := <-events:
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for event")
+		return nil
+	}
+}
+
+func TestOnPrivmsg(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+	defer irc.Close()
+
+	events := make(chan interface{}, 3)
+	irc.OnPrivmsg(func(e MessageEvent) { events <- e })
+
+	server.send(
+		":marvin!~marvin@hhgg.org PRIVMSG #got :hello?",
+		":marvin!~marvin@hhgg.org PRIVMSG got :\x01VERSION\x01",
+		":marvin!~marvin@hhgg.org PRIVMSG got :\x01ACTION sighs\x01",
+	)
+
+	e := receive(t, events).(MessageEvent)
+	assert.Equal(t, "marvin", e.Sender.Nick)
+	assert.Equal(t, "#got", e.Target)
+	assert.Equal(t, "hello?", e.Text)
+	assert.False(t, e.Private)
+	assert.False(t, e.Action)
+
+	e = receive(t, events).(MessageEvent)
+	assert.Equal(t, "sighs", e.Text)
+	assert.True(t, e.Private)
+	assert.True(t, e.Action)
+}
+
+func TestTypedEvents(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+	defer irc.Close()
+
+	events := make(chan interface{}, 8)
+	irc.OnJoin(func(e JoinEvent) { events <- e })
+	irc.OnPart(func(e PartEvent) { events <- e })
+	irc.OnQuit(func(e QuitEvent) { events <- e })
+	irc.OnNick(func(e NickEvent) { events <- e })
+	irc.OnKick(func(e KickEvent) { events <- e })
+	irc.OnMode(func(e ModeEvent) { events <- e })
+	irc.OnTopic(func(e TopicEvent) { events <- e })
+	irc.OnInvite(func(e InviteEvent) { events <- e })
+
+	marvin := Prefix{"marvin", "~marvin", "hhgg.org"}
+	lines := []string{
+		":marvin!~marvin@hhgg.org JOIN #got",
+		":marvin!~marvin@hhgg.org PART #got :bye",
+		":marvin!~marvin@hhgg.org QUIT :Quit: life",
+		":marvin!~marvin@hhgg.org NICK :paranoid",
+		":marvin!~marvin@hhgg.org KICK #got arthur :towel",
+		":marvin!~marvin@hhgg.org MODE #got +ov arthur ford",
+		":marvin!~marvin@hhgg.org TOPIC #got :Don't panic",
+		":marvin!~marvin@hhgg.org INVITE got #beer",
+	}
+	expected := []func(interface{}){
+		func(e interface{}) {
+			assert.Equal(t, marvin, e.(JoinEvent).User)
+			assert.Equal(t, "#got", e.(JoinEvent).Channel)
+		},
+		func(e interface{}) {
+			assert.Equal(t, "#got", e.(PartEvent).Channel)
+			assert.Equal(t, "bye", e.(PartEvent).Reason)
+		},
+		func(e interface{}) { assert.Equal(t, "Quit: life", e.(QuitEvent).Reason) },
+		func(e interface{}) {
+			assert.Equal(t, "marvin", e.(NickEvent).User.Nick)
+			assert.Equal(t, "paranoid", e.(NickEvent).Nick)
+		},
+		func(e interface{}) {
+			assert.Equal(t, KickEvent{Message: e.(KickEvent).Message, By: marvin, Channel: "#got", Nick: "arthur", Reason: "towel"}, e)
+		},
+		func(e interface{}) {
+			assert.Equal(t, "+ov", e.(ModeEvent).Modes)
+			assert.Equal(t, []string{"arthur", "ford"}, e.(ModeEvent).Params)
+		},
+		func(e interface{}) { assert.Equal(t, "Don't panic", e.(TopicEvent).Topic) },
+		func(e interface{}) {
+			assert.Equal(t, "got", e.(InviteEvent).Nick)
+			assert.Equal(t, "#beer", e.(InviteEvent).Channel)
+		},
+	}
+
+	// Each handler runs in its own goroutine, so events of
+	// different types may arrive in any order: send one at
+	// a time.
+	for i, line := range lines {
+		server.send(line)
+		expected[i](receive(t, events))
+	}
+}
+
+func TestRemoveEventHandler(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+	defer irc.Close()
+
+	removed := make(chan interface{}, 1)
+	kept := make(chan interface{}, 2)
+	h := irc.OnJoin(func(e JoinEvent) { removed <- e })
+	irc.OnJoin(func(e JoinEvent) { kept <- e })
+
+	h.Remove()
+	h.Remove()
+
+	server.send(":marvin!~marvin@hhgg.org JOIN #got")
+	receive(t, kept)
+	assert.Len(t, removed, 0)
+}
+
+func TestSlowEventHandlerDoesNotBlock(t *testing.T) {
+	irc, server := newTestIRC(t, Config{EventQueueSize: 2})
+	defer irc.Close()
+
+	stuck := make(chan bool)
+	defer close(stuck)
+	irc.OnPrivmsg(func(e MessageEvent) { <-stuck })
+
+	fast := make(chan interface{}, 6)
+	irc.OnPrivmsg(func(e MessageEvent) { fast <- e })
+
+	// The stuck handler misses most messages, but
+	// neither the client nor the other handler wait.
+	for i := 0; i < 6; i++ {
+		server.send(":marvin!~marvin@hhgg.org PRIVMSG #got :hello?")
+		server.sync()
+	}
+
+	for i := 0; i < 6; i++ {
+		receive(t, fast)
+	}
+}
+
+func TestEventHandlerPanicIsRecovered(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+	defer irc.Close()
+
+	events := make(chan interface{}, 1)
+	irc.OnNotice(func(e MessageEvent) {
+		if e.Text == "boom" {
+			panic("boom")
+		}
+		events <- e
+	})
+
+	server.send(
+		":irc.example.com NOTICE got :boom",
+		":irc.example.com NOTICE got :still here",
+	)
+	assert.Equal(t, "still here", receive(t, events).(MessageEvent).Text)
+}
+
+func TestOnMessage(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+	defer irc.Close()
+
+	events := make(chan interface{}, 1)
+	irc.OnMessage("372", func(msg Message) { events <- msg })
+
+	server.send(":irc.example.com 372 got :- Welcome to the MOTD")
+	assert.Equal(t, "- Welcome to the MOTD", receive(t, events).(Message).Trailing)
+}
+
+func TestOnMessageIgnoresCase(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+	defer irc.Close()
+
+	events := make(chan interface{}, 1)
+	irc.OnMessage("wallops", func(msg Message) { events <- msg })
+
+	server.send(":marvin!~marvin@hhgg.org WALLOPS :Life. Loathe it or ignore it.")
+	assert.Equal(t, "Life. Loathe it or ignore it.", receive(t, events).(Message).Trailing)
+}
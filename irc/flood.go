@@ -0,0 +1,147 @@
+package irc
+
+import (
+	"time"
+)
+
+// Default flood control settings, following the recommendation
+// in RFC 1459 (section 8.10): after an initial burst, one message
+// every two seconds.
+const (
+	DefaultFloodBurst    = 5
+	DefaultFloodInterval = 2 * time.Second
+)
+
+// tokenBucket implements the token bucket algorithm used to
+// limit the rate of outgoing messages. Each message takes a
+// token, and tokens are refilled at a fixed interval, up to
+// the bucket capacity.
+type tokenBucket struct {
+	// The maximum number of tokens, i.e. the number of
+	// messages that can be sent in a burst.
+	capacity float64
+
+	// How long it takes to refill a token.
+	interval time.Duration
+
+	// The number of tokens currently available.
+	tokens float64
+
+	// When the tokens were last refilled.
+	last time.Time
+
+	// Returns the current time; replaceable in tests.
+	now func() time.Time
+}
+
+// newTokenBucket creates a full bucket from the flood control
+// settings. If the configured interval is negative, flood
+// control is disabled and nil is returned.
+func newTokenBucket(config Config) *tokenBucket {
+	burst, interval := config.FloodBurst, config.FloodInterval
+	if interval < 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = DefaultFloodBurst
+	}
+	if interval == 0 {
+		interval = DefaultFloodInterval
+	}
+
+	return newBucket(burst, interval)
+}
+
+// newBucket creates a full bucket with the given capacity,
+// refilling a token every interval.
+func newBucket(burst int, interval time.Duration) *tokenBucket {
+	return &tokenBucket{
+		capacity: float64(burst),
+		interval: interval,
+		tokens:   float64(burst),
+		last:     time.Now(),
+		now:      time.Now,
+	}
+}
+
+// take takes a token from the bucket, if available, and
+// reports whether it was successful. A nil bucket always
+// has tokens available.
+func (b *tokenBucket) take() bool {
+	if b == nil {
+		return true
+	}
+
+	b.refill()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// delay returns how long it takes until a token is available.
+func (b *tokenBucket) delay() time.Duration {
+	b.refill()
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) * float64(b.interval))
+}
+
+// refill adds the tokens accumulated since the last refill.
+func (b *tokenBucket) refill() {
+	now := b.now()
+	b.tokens += float64(now.Sub(b.last)) / float64(b.interval)
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+	b.last = now
+}
+
+// handleWrite reads messages from the priority and out channels
+// and sends them over the wire. Messages from the priority channel
+// (e.g. PONG and registration traffic) are sent right away, ahead
+// of any queued ones; the others are subject to flood control.
+func (irc *IRC) handleWrite() {
+	defer irc.wg.Done()
+
+	bucket := newTokenBucket(irc.config)
+
+	for {
+		select {
+		case msg := <-irc.priority:
+			irc.send(msg)
+			continue
+		default:
+		}
+
+		select {
+		case msg := <-irc.priority:
+			irc.send(msg)
+		case msg := <-irc.out:
+			if !irc.throttle(bucket) {
+				return
+			}
+			irc.send(msg)
+		case <-irc.done:
+			return
+		}
+	}
+}
+
+// throttle waits until a token is available in the given bucket,
+// sending priority messages in the meantime. It returns false if
+// the client is closed while waiting.
+func (irc *IRC) throttle(bucket *tokenBucket) bool {
+	for !bucket.take() {
+		select {
+		case msg := <-irc.priority:
+			irc.send(msg)
+		case <-time.After(bucket.delay()):
+		case <-irc.done:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestBucket creates a bucket controlled by the returned clock.
+func newTestBucket(burst int, interval time.Duration) (*tokenBucket, *time.Time) {
+	now := time.Now()
+	bucket := newTokenBucket(Config{FloodBurst: burst, FloodInterval: interval})
+	bucket.last = now
+	bucket.now = func() time.Time { return now }
+
+	return bucket, &now
+}
+
+func TestTokenBucketBurst(t *testing.T) {
+	bucket, _ := newTestBucket(3, time.Second)
+
+	assert.True(t, bucket.take())
+	assert.True(t, bucket.take())
+	assert.True(t, bucket.take())
+	assert.False(t, bucket.take())
+	assert.Equal(t, time.Second, bucket.delay())
+}
+
+func TestTokenBucketRefill(t *testing.T) {
+	bucket, now := newTestBucket(2, time.Second)
+
+	bucket.take()
+	bucket.take()
+
+	*now = now.Add(500 * time.Millisecond)
+	assert.False(t, bucket.take())
+	assert.Equal(t, 500*time.Millisecond, bucket.delay())
+
+	*now = now.Add(500 * time.Millisecond)
+	assert.True(t, bucket.take())
+	assert.False(t, bucket.take())
+
+	*now = now.Add(time.Hour)
+	assert.True(t, bucket.take())
+	assert.True(t, bucket.take())
+	assert.False(t, bucket.take())
+}
+
+func TestTokenBucketDefaults(t *testing.T) {
+	bucket := newTokenBucket(Config{})
+
+	assert.Equal(t, float64(DefaultFloodBurst), bucket.capacity)
+	assert.Equal(t, DefaultFloodInterval, bucket.interval)
+}
+
+func TestTokenBucketDisabled(t *testing.T) {
+	bucket := newTokenBucket(Config{FloodInterval: -1})
+
+	assert.Nil(t, bucket)
+	for i := 0; i < 100; i++ {
+		assert.True(t, bucket.take())
+	}
+}
+
+func TestFloodControlLetsPriorityMessagesThrough(t *testing.T) {
+	irc, server := newTestIRC(t, Config{FloodBurst: 1, FloodInterval: time.Hour})
+
+	go irc.SendMessages("#got", "first", "second")
+	server.expect("PRIVMSG #got :first")
+
+	server.send("PING :irc.example.com")
+	server.expect("PONG :irc.example.com")
+}
+
+func TestFloodControlThrottlesMessages(t *testing.T) {
+	irc, server := newTestIRC(t, Config{FloodBurst: 1, FloodInterval: 50 * time.Millisecond})
+
+	go irc.SendMessages("#got", "first", "second")
+	server.expect("PRIVMSG #got :first")
+
+	start := time.Now()
+	server.expect("PRIVMSG #got :second")
+	assert.True(t, time.Since(start) >= 40*time.Millisecond)
+}
@@ -0,0 +1,244 @@
+// Package format provides the mIRC formatting codes used to style
+// IRC messages (colors, bold, italics, etc.), as well as functions
+// to remove them from incoming text.
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The formatting control codes. Each of them toggles its style,
+// except for ColorCode and HexColorCode, which are followed by
+// the colors to use, and ResetCode, which resets all the styles.
+const (
+	BoldCode          = '\x02'
+	ColorCode         = '\x03'
+	HexColorCode      = '\x04'
+	ResetCode         = '\x0f'
+	MonospaceCode     = '\x11'
+	ReverseCode       = '\x16'
+	ItalicCode        = '\x1d'
+	StrikethroughCode = '\x1e'
+	UnderlineCode     = '\x1f'
+)
+
+// Color is one of the 16 standard mIRC colors.
+type Color int
+
+// The standard mIRC colors.
+const (
+	White Color = iota
+	Black
+	Blue
+	Green
+	Red
+	Brown
+	Magenta
+	Orange
+	Yellow
+	LightGreen
+	Cyan
+	LightCyan
+	LightBlue
+	Pink
+	Grey
+	LightGrey
+)
+
+// The codes written between a color code and text that would
+// otherwise be read as part of the colors: bold, toggled on and off.
+const separator = "\x02\x02"
+
+// code returns the color as a two-digit code, so that
+// the text that follows can start with a digit.
+func (c Color) code() string {
+	return fmt.Sprintf("%02d", int(c))
+}
+
+// Builder builds styled messages. Each styled piece of text is
+// closed after it's written, so that styles don't leak into the
+// text that follows. The zero value is ready to use.
+type Builder struct {
+	sb strings.Builder
+
+	// The bytes that need a separator when the text that follows
+	// starts with them, since they'd be read as part of the color
+	// code just written: a comma after the two-digit foreground
+	// color, or a digit after the code closing the color.
+	ambiguous string
+}
+
+// Text appends the given text without styling it.
+func (b *Builder) Text(text string) *Builder {
+	b.write(text)
+	return b
+}
+
+// Bold appends the given text in bold.
+func (b *Builder) Bold(text string) *Builder {
+	return b.toggle(BoldCode, text)
+}
+
+// Italic appends the given text in italics.
+func (b *Builder) Italic(text string) *Builder {
+	return b.toggle(ItalicCode, text)
+}
+
+// Underline appends the given text underlined.
+func (b *Builder) Underline(text string) *Builder {
+	return b.toggle(UnderlineCode, text)
+}
+
+// Strikethrough appends the given text struck through.
+func (b *Builder) Strikethrough(text string) *Builder {
+	return b.toggle(StrikethroughCode, text)
+}
+
+// Monospace appends the given text in a monospace font.
+func (b *Builder) Monospace(text string) *Builder {
+	return b.toggle(MonospaceCode, text)
+}
+
+// Reverse appends the given text with the foreground and
+// background colors swapped.
+func (b *Builder) Reverse(text string) *Builder {
+	return b.toggle(ReverseCode, text)
+}
+
+// Color appends the given text in the given color.
+func (b *Builder) Color(fg Color, text string) *Builder {
+	return b.color(string(ColorCode)+fg.code(), text)
+}
+
+// ColorOn appends the given text in the given color,
+// on the given background color.
+func (b *Builder) ColorOn(fg, bg Color, text string) *Builder {
+	return b.color(string(ColorCode)+fg.code()+","+bg.code(), text)
+}
+
+// String returns the message built so far.
+func (b *Builder) String() string {
+	return b.sb.String()
+}
+
+// toggle appends the given text between the given control codes.
+func (b *Builder) toggle(code rune, text string) *Builder {
+	b.write(string(code))
+	b.write(text)
+	b.write(string(code))
+	return b
+}
+
+// color appends the given text after the given color codes,
+// and resets the color afterwards.
+func (b *Builder) color(codes, text string) *Builder {
+	b.write(codes)
+	b.ambiguous = ","
+	b.write(text)
+	b.write(string(ColorCode))
+	b.ambiguous = "0123456789"
+	return b
+}
+
+// write appends the given text, preceded by a separator if it
+// follows a color code and would be read as part of the colors.
+func (b *Builder) write(text string) {
+	if text == "" {
+		return
+	}
+	if strings.IndexByte(b.ambiguous, text[0]) >= 0 {
+		b.sb.WriteString(separator)
+	}
+	b.ambiguous = ""
+	b.sb.WriteString(text)
+}
+
+// Strip removes all the formatting codes from the given text.
+func Strip(text string) string {
+	return strip(text, true)
+}
+
+// StripColors removes the color codes from the given text,
+// keeping the other styles (e.g. for channels with mode +c,
+// which block colored messages).
+func StripColors(text string) string {
+	return strip(text, false)
+}
+
+// CodeLength returns the length of the formatting code at the
+// start of the given text, including the colors that follow a
+// color code, or 0 if the text doesn't start with one.
+func CodeLength(text string) int {
+	if text == "" {
+		return 0
+	}
+
+	switch text[0] {
+	case ColorCode:
+		return 1 + colorLength(text[1:], 2, isDigit)
+	case HexColorCode:
+		return 1 + colorLength(text[1:], 6, isHexDigit)
+	case BoldCode, ResetCode, MonospaceCode, ReverseCode, ItalicCode, StrikethroughCode, UnderlineCode:
+		return 1
+	}
+	return 0
+}
+
+// strip removes the color codes from the given text and,
+// if all is true, the other formatting codes as well.
+func strip(text string, all bool) string {
+	if !strings.ContainsAny(text, "\x02\x03\x04\x0f\x11\x16\x1d\x1e\x1f") {
+		return text
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(text); {
+		switch n := CodeLength(text[i:]); {
+		case n == 0:
+			sb.WriteByte(text[i])
+			i++
+		case !all && text[i] != ColorCode && text[i] != HexColorCode:
+			sb.WriteString(text[i : i+n])
+			i += n
+		default:
+			i += n
+		}
+	}
+	return sb.String()
+}
+
+// colorLength returns the length of the colors at the start of the
+// given text, which follow a color code: a foreground color of up to
+// max digits, optionally followed by a comma and a background color.
+// A comma not followed by a color is part of the text.
+func colorLength(text string, max int, digit func(byte) bool) int {
+	n := digits(text, max, digit)
+	if n == 0 || n == len(text) || text[n] != ',' {
+		return n
+	}
+	if bg := digits(text[n+1:], max, digit); bg > 0 {
+		return n + 1 + bg
+	}
+	return n
+}
+
+// digits returns the number of digits, up to max, at the
+// start of the given text.
+func digits(text string, max int, digit func(byte) bool) int {
+	n := 0
+	for n < len(text) && n < max && digit(text[n]) {
+		n++
+	}
+	return n
+}
+
+// isDigit reports whether the given byte is a decimal digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// isHexDigit reports whether the given byte is a hexadecimal digit.
+func isHexDigit(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
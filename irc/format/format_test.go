@@ -0,0 +1,62 @@
+package format
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuilder(t *testing.T) {
+	var b Builder
+	b.Text("weather: ").Bold("Lisbon").Text(", ").Color(Red, "30°C").Text(" ").Italic("sunny")
+
+	assert.Equal(t, "weather: \x02Lisbon\x02, \x030430°C\x03 \x1dsunny\x1d", b.String())
+}
+
+func TestBuilderStyles(t *testing.T) {
+	var b Builder
+	b.Underline("u").Strikethrough("s").Monospace("m").Reverse("r").ColorOn(White, Blue, "c")
+
+	assert.Equal(t, "\x1fu\x1f\x1es\x1e\x11m\x11\x16r\x16\x0300,02c\x03", b.String())
+}
+
+func TestBuilderSeparatesColorsFromDigits(t *testing.T) {
+	var b Builder
+	b.Color(Red, "x").Text("5 items").Color(Blue, ",5").Text(", done")
+
+	assert.Equal(t, "\x0304x\x03\x02\x025 items\x0302\x02\x02,5\x03, done", b.String())
+	assert.Equal(t, "x5 items,5, done", Strip(b.String()))
+}
+
+func TestStrip(t *testing.T) {
+	cases := map[string]string{
+		"plain text":                  "plain text",
+		"\x02!got\x02 weather":        "!got weather",
+		"\x034red\x03 \x0312,1blue":   "red blue",
+		"\x0304,12 on blue\x0f reset": " on blue reset",
+		"\x0399 bottles":              " bottles",
+		"\x03,5 no color":             ",5 no color",
+		"\x035, comma":                ", comma",
+		"\x04FF0000,00ff00hex\x04":    "hex",
+		"\x1dit\x1d \x1fu\x1f \x16r":  "it u r",
+		"\x03":                        "",
+	}
+	for text, expected := range cases {
+		assert.Equal(t, expected, Strip(text), "%q", text)
+	}
+}
+
+func TestStripColors(t *testing.T) {
+	assert.Equal(t, "\x02bold\x02 red", StripColors("\x02bold\x02 \x0304red\x03"))
+}
+
+func TestCodeLength(t *testing.T) {
+	assert.Equal(t, 0, CodeLength(""))
+	assert.Equal(t, 0, CodeLength("text"))
+	assert.Equal(t, 1, CodeLength("\x02bold"))
+	assert.Equal(t, 1, CodeLength("\x03"))
+	assert.Equal(t, 3, CodeLength("\x03123"))
+	assert.Equal(t, 6, CodeLength("\x0304,12text"))
+	assert.Equal(t, 2, CodeLength("\x034,"))
+	assert.Equal(t, 14, CodeLength("\x04FF0000,00ff00"))
+}
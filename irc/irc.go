@@ -4,178 +4,462 @@ package irc
 import (
 	"bufio"
 	"fmt"
-	"io"
 	"log"
 	"net"
-	"regexp"
 	"strings"
-	"time"
+	"sync"
 )
 
-// IRC represents an connection to a channel.
+// Filter reports whether a message should be delivered
+// to a subscription.
+type Filter func(Message) bool
+
+// MatchCommand returns a Filter that matches messages
+// with any of the given commands.
+func MatchCommand(commands ...string) Filter {
+	return func(msg Message) bool {
+		for _, c := range commands {
+			if strings.EqualFold(msg.Command, c) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// MatchAll returns a Filter that matches messages
+// matched by all of the given filters.
+func MatchAll(filters ...Filter) Filter {
+	return func(msg Message) bool {
+		for _, f := range filters {
+			if !f(msg) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// IRC represents a connection to a server.
 type IRC struct {
-	// The IRC server to connect to.
-	server string
+	// The connection settings.
+	config Config
 
-	// The server port to connect to.
-	port int
+	// The channels the client is in.
+	channels *channels
 
-	// The IRC channel to connect to.
-	Channel string
+	// Guards the connection and the registration details,
+	// which change when reconnecting.
+	mu sync.RWMutex
 
 	// The connection to the IRC server.
 	conn net.Conn
 
+	// The current connection session, replaced every
+	// time the client reconnects.
+	session *session
+
+	// The user the client registered with, which is
+	// also its primary nickname.
+	user string
+
+	// The client prefix, as seen by the server.
+	self Prefix
+
+	// Whether the client has completed the registration.
+	registered bool
+
+	// The number of unavailable nicknames tried
+	// while registering.
+	nickAttempts int
+
 	// The channel where to send PING messages.
-	ping chan string
+	ping chan Message
 
 	// The channel where to send messages that should
 	// be sent back to the server.
 	out chan string
 
-	// A map where the key is a regexp pattern to be matched against,
-	// and the value is a channel where to send messages that match
-	// the specified pattern.
-	subscriptions map[*regexp.Regexp]chan string
+	// The channel where to send messages that should be sent
+	// back to the server ahead of the ones in the out channel,
+	// bypassing flood control.
+	priority chan string
+
+	// The channel where to send the replies to messages received
+	// from the server (e.g. CTCP replies), which are queued from
+	// there, so that flood control doesn't hold up the reader.
+	replies chan string
+
+	// The message subscriptions.
+	subscriptions *subscriptions
+
+	// The capability negotiation state.
+	caps *capabilities
+
+	// The registration state.
+	registration *registration
+
+	// The channels the client is in and their members.
+	state *state
+
+	// Limits the rate of CTCP replies.
+	ctcpFlood *tokenBucket
+
+	// The PINGs sent to the server and the lag measured.
+	lag *lag
+
+	// The event handlers.
+	events *events
+
+	// The encodings used on the wire.
+	charsets *charsets
+
+	// The callbacks invoked when connecting and disconnecting.
+	callbacks *callbacks
+
+	// Whether the connection was lost and the client is
+	// waiting for Reconnect to be called.
+	failed bool
+
+	// Why registering failed after reconnecting, if it did, in
+	// which case the connection is dropped and the error reported
+	// instead of reconnecting again.
+	registerErr error
+
+	// Whether the client is shutting down, in which case
+	// no more messages are accepted for sending.
+	closing bool
+
+	// Tracks the messages being queued for sending,
+	// so that shutting down waits for them.
+	pending sync.WaitGroup
+
+	// Tracks the goroutines handling the connection,
+	// so that closing waits for them to finish.
+	wg sync.WaitGroup
+
+	// Makes sure the client is closed only once.
+	closeOnce sync.Once
+
+	// The channel where failures the client can't recover
+	// from on its own are reported.
+	errors chan error
+
+	// The channel where Reconnect requests are sent, along with
+	// the channel where to send the result.
+	resume chan chan error
+
+	// Closed when the reader goroutine finishes, which happens
+	// once the server closes the connection after QUIT.
+	stopped chan struct{}
+
+	// Closed when the client is closed, in order to stop
+	// all the goroutines handling the connection.
+	done chan struct{}
 }
 
-// New connects to the specified server:port and returns
-// an IRC value for interacting with the server.
-func NewIRC(server string, port int, channel string) IRC {
-	conn := connect(server, port)
+// NewIRC connects to the configured server and returns
+// an IRC value for interacting with the server. If the server
+// cannot be reached, it retries according to the configured
+// reconnection policy, and returns an error once it gives up.
+func NewIRC(config Config) (*IRC, error) {
+	conn, err := connect(config, nil)
+	if err != nil {
+		return nil, err
+	}
+	return newIRC(config, conn), nil
+}
 
-	irc := IRC{
-		server:        server,
-		port:          port,
-		Channel:       channel,
+// newIRC returns an IRC value using the given connection
+// and starts handling its messages.
+func newIRC(config Config, conn net.Conn) *IRC {
+	irc := &IRC{
+		config:        config,
+		channels:      newChannels(config.Channels),
 		conn:          conn,
-		ping:          make(chan string),
+		session:       newSession(),
+		ping:          make(chan Message),
 		out:           make(chan string),
-		subscriptions: make(map[*regexp.Regexp]chan string),
+		priority:      make(chan string),
+		replies:       make(chan string, maxPendingReplies),
+		subscriptions: &subscriptions{},
+		caps:          newCapabilities(config),
+		registration:  &registration{},
+		state:         newState(),
+		ctcpFlood:     newCTCPBucket(config),
+		lag:           &lag{},
+		events:        newEvents(config),
+		charsets:      newCharsets(config),
+		callbacks:     &callbacks{},
+		errors:        make(chan error, 1),
+		resume:        make(chan chan error),
+		stopped:       make(chan struct{}),
+		done:          make(chan struct{}),
 	}
 
+	irc.wg.Add(6)
 	go irc.handleRead()
 	go irc.handlePing()
 	go irc.handleWrite()
+	go irc.handleReplies()
+	go irc.handleNickReclaim()
+	go irc.handleLag()
 
 	return irc
 }
 
-// Close closes the underlying IRC connection.
-func (irc IRC) Close() {
-	irc.conn.Close()
-
-	close(irc.ping)
-	close(irc.out)
-	for _, c := range irc.subscriptions {
-		close(c)
+// SendMessages sends the given list of messages over the wire
+// to the given target, which can be either a channel or a nickname.
+// Messages that exceed the protocol length limit are split into
+// multiple ones. Messages created with Action are sent as such.
+func (irc *IRC) SendMessages(target string, messages ...string) {
+	if !irc.sending() {
+		return
 	}
-}
+	defer irc.pending.Done()
 
-// SendMessages sends the given list of messages over the wire
-// to the connected channel.
-func (irc IRC) SendMessages(messages ...string) {
 	for _, msg := range messages {
-		irc.out <- fmt.Sprintf("PRIVMSG %s :%s", irc.Channel, msg)
+		if ctcp, ok := ParseCTCP(msg); ok && ctcp.Command == "ACTION" {
+			irc.sendCTCP("PRIVMSG", target, "ACTION", ctcp.Params)
+			continue
+		}
+		irc.sendText("PRIVMSG", target, msg)
 	}
 }
 
-// Join joins the configured channel with the given
-// user credentials.
-func (irc IRC) Join(user string, passwd string) {
-	irc.out <- fmt.Sprintf("NICK %s", user)
-	irc.out <- fmt.Sprintf("USER %s 0.0.0.0 0.0.0.0 :%s", user, user)
-	irc.out <- fmt.Sprintf("JOIN %s %s", irc.Channel, passwd)
+// Join registers the given user and joins the configured
+// channels. The capability negotiation, including the
+// SASL authentication if enabled, takes place before joining;
+// an error is returned if the authentication fails and the
+// configuration requires aborting on SASL failures.
+// The channels are only joined once the server has completed
+// the registration; an error is returned if it refuses it
+// (e.g. wrong password or banned) or doesn't complete it in time.
+// The same steps are repeated automatically after reconnecting;
+// if the connection is lost while registering, the result is
+// the one of the registration over the new connection.
+func (irc *IRC) Join(user string) error {
+	irc.mu.Lock()
+	irc.user = user
+	session := irc.session
+	irc.mu.Unlock()
+
+	err := irc.register(session)
+	for err == errStaleSession {
+		err = irc.currentSession().wait(irc.done)
+	}
+	return err
 }
 
-// Subscribe configures a message subscription pattern that,
-// when matched, causes the message to be sent to the specified
-// channel.
-func (irc IRC) Subscribe(pattern *regexp.Regexp, channel chan string) {
-	irc.subscriptions[pattern] = channel
+// register registers the user with the server and joins the
+// channels, notifying the OnConnect callbacks when done, and
+// records the result in the given session. If the client
+// reconnects in the meantime, i.e. the given session is
+// replaced, it gives up right away.
+func (irc *IRC) register(session *session) (err error) {
+	defer func() { session.finish(err) }()
+
+	irc.mu.Lock()
+	user := irc.user
+	irc.self = Prefix{Nick: user}
+	irc.registered = false
+	irc.nickAttempts = 0
+	irc.mu.Unlock()
+
+	registered := irc.registration.start()
+	irc.lag.reset()
+
+	if irc.config.Password != "" {
+		irc.queuePriority(fmt.Sprintf("PASS %s", irc.config.Password))
+	}
+
+	negotiated := irc.caps.start(irc.queuePriority)
+
+	irc.queuePriority(fmt.Sprintf("NICK %s", user))
+	irc.queuePriority(fmt.Sprintf("USER %s 0.0.0.0 0.0.0.0 :%s", user, user))
+
+	if err := irc.caps.wait(negotiated, irc.done, session.replaced); err == ErrClosed || err == errStaleSession {
+		return err
+	} else if err != nil {
+		log.Printf("[IRC] Capability negotiation failed: %v\n", err)
+		if irc.config.SASLAbortOnFailure && irc.config.SASLMechanism != "" {
+			return err
+		}
+	}
+
+	if err := irc.registration.wait(registered, irc.done, session.replaced); err != nil {
+		return err
+	}
+
+	if irc.currentSession() != session {
+		return errStaleSession
+	}
+
+	for _, channel := range irc.channels.list() {
+		irc.queue(joinCommand(channel))
+	}
+
+	irc.callbacks.connected()
+	return nil
 }
 
-// handleRead reads all messages sent to the IRC channel.
-// If it's a "PING" message, forwards it to the ping channel;
-// otherwise, looks for a subscription that matches the message
-// and forwards it to the registered channel.
+// handleRead reads and parses all messages sent to the IRC
+// channel, reconnecting when the connection is lost. Failures
+// to reconnect, as well as connections lost while reconnecting
+// is disabled, are reported on the errors channel.
 func (irc *IRC) handleRead() {
-	buf := bufio.NewReaderSize(irc.conn, 512)
+	defer irc.wg.Done()
+	defer close(irc.stopped)
 
 	for {
-		msg, err := buf.ReadString('\n')
-		if err != nil {
-			if recoverable(err) {
-				log.Printf("Error [%s] while reading message, reconnecting in 1s...\n", err)
-				<-time.After(1 * time.Second)
+		err := irc.read(irc.connection())
+		if irc.isClosing() {
+			return
+		}
+
+		log.Printf("[IRC] Error [%s] while reading message\n", err)
+		irc.callbacks.disconnected(err)
 
-				irc.conn = connect(irc.server, irc.port)
+		irc.mu.Lock()
+		registerErr := irc.registerErr
+		irc.registerErr = nil
+		irc.mu.Unlock()
 
-				continue
-			} else {
-				log.Fatalf("Unrecoverable error while reading message: %v\n", err)
+		if registerErr != nil {
+			err = registerErr
+		} else if !irc.config.DisableReconnect {
+			log.Println("[IRC] Reconnecting...")
+			err = irc.reconnect()
+		}
+		for err != nil {
+			if err == ErrClosed || !irc.fail(err) {
+				return
 			}
+			err = irc.waitReconnect()
 		}
+	}
+}
 
-		msg = msg[:len(msg)-2]
-		if strings.Contains(msg, "PING") {
-			irc.ping <- msg
-		} else {
-			for pattern, channel := range irc.subscriptions {
-				if pattern.Match([]byte(msg)) {
-					channel <- msg
-				}
-			}
+// fail reports the given error on the errors channel, so that
+// the caller can decide whether to reconnect. It returns false
+// if the client is closed in the meantime.
+func (irc *IRC) fail(err error) bool {
+	irc.mu.Lock()
+	irc.failed = true
+	irc.mu.Unlock()
+
+	select {
+	case irc.errors <- err:
+		return true
+	case <-irc.done:
+		return false
+	}
+}
+
+// waitReconnect waits for Reconnect to be called, reconnects
+// and sends the result back. It returns ErrClosed if the client
+// is closed in the meantime.
+func (irc *IRC) waitReconnect() error {
+	select {
+	case result := <-irc.resume:
+		err := irc.reconnect()
+		if err == nil {
+			irc.mu.Lock()
+			irc.failed = false
+			irc.mu.Unlock()
 		}
+		result <- err
+		return err
+	case <-irc.done:
+		return ErrClosed
 	}
 }
 
-// handleWrite reads messages from the out channel
-// and sends them over the wire.
-func (irc IRC) handleWrite() {
-	for msg := range irc.out {
-		irc.send(msg)
+// read reads and parses all messages sent over the given
+// connection, until an error occurs. If it's a "PING" message,
+// forwards it to the ping channel; otherwise, looks for
+// subscriptions whose filters match the message and forwards
+// it to the registered channels.
+func (irc *IRC) read(conn net.Conn) error {
+	buf := bufio.NewReaderSize(conn, 512)
+
+	for {
+		msg, err := buf.ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		parsed, err := ParseMessage(irc.charsets.decode(msg))
+		if err != nil {
+			log.Printf("[IRC] Ignoring malformed message %q: %v\n", msg, err)
+			continue
+		}
+
+		if parsed.Command == "PING" {
+			select {
+			case irc.ping <- parsed:
+			case <-irc.done:
+			}
+		} else {
+			irc.handleNick(parsed)
+			irc.trackSelf(parsed)
+			irc.state.handle(parsed, irc.Nick())
+			irc.requestWho(parsed)
+			irc.handleCTCP(parsed)
+			irc.registration.handle(parsed)
+			irc.caps.handle(parsed, irc.queuePriority)
+			irc.lag.handle(parsed)
+			irc.events.dispatch(parsed)
+
+			irc.subscriptions.dispatch(parsed, irc.done)
+		}
 	}
 }
 
 // handlePing reads messages from the ping channel
 // and sends the "PONG" response to the server originating
 // the "PING" request.
-func (irc IRC) handlePing() {
-	for ping := range irc.ping {
-		server := strings.Split(ping, ":")[1]
+func (irc *IRC) handlePing() {
+	defer irc.wg.Done()
+
+	for {
+		select {
+		case ping := <-irc.ping:
+			server := ping.Arg(0)
 
-		irc.out <- fmt.Sprintf("PONG %s", server)
-		log.Printf("[IRC] PONG sent to %s\n", server)
+			irc.queuePriority(fmt.Sprintf("PONG :%s", server))
+			log.Printf("[IRC] PONG sent to %s\n", server)
+		case <-irc.done:
+			return
+		}
 	}
 }
 
 // send is responsible for writing the bytes over the wire.
-func (irc IRC) send(msg string) {
-	_, err := irc.conn.Write([]byte(fmt.Sprintf("%s\r\n", msg)))
+// If writing fails, the connection is closed so that the
+// client reconnects.
+func (irc *IRC) send(msg string) {
+	conn := irc.connection()
+
+	_, err := conn.Write(append(irc.charsets.encodeFor(conn, msg), '\r', '\n'))
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("[IRC] Error [%s] while sending message, dropping connection\n", err)
+		conn.Close()
 	}
 }
 
-// connect dials to the configured server and returns
-// the connection.
-func connect(server string, port int) net.Conn {
-	conn, err := net.Dial("tcp", fmt.Sprintf("%s:%d", server, port))
-	if err != nil {
-		log.Fatal(err)
-	}
-	log.Printf("[IRC] Connected to %s (%s).\n", server, conn.RemoteAddr())
-	return conn
+// connection returns the current connection.
+func (irc *IRC) connection() net.Conn {
+	irc.mu.RLock()
+	defer irc.mu.RUnlock()
+
+	return irc.conn
 }
 
-// recoverable checks if the given error is temporary and could
-// be recovered from.
-func recoverable(err error) bool {
-	if e, netError := err.(net.Error); netError && e.Temporary() {
-		return true
-	} else if err == io.EOF {
-		return true
-	}
-	return false
+// currentSession returns the current connection session.
+func (irc *IRC) currentSession() *session {
+	irc.mu.RLock()
+	defer irc.mu.RUnlock()
+
+	return irc.session
 }
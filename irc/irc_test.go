@@ -0,0 +1,62 @@
+package irc
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testServer is the server end of an in-memory connection
+// handed to an IRC value under test.
+type testServer struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// newTestIRC creates an IRC value connected to an in-memory
+// test server.
+func newTestIRC(t *testing.T, config Config) (*IRC, *testServer) {
+	client, server := net.Pipe()
+
+	return newIRC(config, client), &testServer{t, server, bufio.NewReader(server)}
+}
+
+// expect reads the next line sent by the client and checks
+// it matches the expected one.
+func (s *testServer) expect(expected string) {
+	s.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	line, err := s.reader.ReadString('\n')
+	if assert.Nil(s.t, err, "expected %q", expected) {
+		assert.Equal(s.t, expected, strings.TrimRight(line, "\r\n"))
+	}
+}
+
+// send writes the given lines to the client.
+func (s *testServer) send(lines ...string) {
+	for _, line := range lines {
+		s.conn.Write([]byte(line + "\r\n"))
+	}
+}
+
+// sync waits until all the lines previously sent have been
+// handled by the client, by sending a PING and waiting for
+// the corresponding PONG.
+func (s *testServer) sync() {
+	s.send("PING :sync")
+	s.expect("PONG :sync")
+}
+
+// welcome completes the registration of the given nickname,
+// as the server does after the capability negotiation.
+func (s *testServer) welcome(nick string) {
+	s.send(
+		":irc.example.com 001 "+nick+" :Welcome",
+		":irc.example.com 422 "+nick+" :MOTD File is missing",
+	)
+}
@@ -0,0 +1,153 @@
+package irctest
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/caiofilipini/got/irc"
+)
+
+// Client is a client connected to the server. Its fields are
+// guarded by the server, except for the ones guarded by mu.
+type Client struct {
+	// The server end of the connection.
+	conn net.Conn
+
+	// The client username.
+	user string
+
+	// Whether the client has completed the registration.
+	registered bool
+
+	// Whether the capability negotiation is in progress,
+	// which holds the registration.
+	negotiating bool
+
+	// The capabilities enabled by the client.
+	caps map[string]bool
+
+	// Guards the fields below.
+	mu sync.Mutex
+
+	// The client nickname.
+	nick string
+
+	// The lines waiting to be sent to the client. They are
+	// queued, rather than written right away, so that the
+	// server never blocks on a client that is busy writing.
+	queue []string
+
+	// Signals the writer when lines are queued, or the
+	// client is closed.
+	cond *sync.Cond
+
+	// Whether the client has been closed.
+	closed bool
+}
+
+// newClient creates a client for the given connection,
+// and starts writing to it.
+func newClient(s *Server, conn net.Conn) *Client {
+	c := &Client{
+		conn: conn,
+		caps: make(map[string]bool),
+	}
+	c.cond = sync.NewCond(&c.mu)
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		c.write()
+	}()
+
+	return c
+}
+
+// Nick returns the client nickname.
+func (c *Client) Nick() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.nick
+}
+
+// setNick changes the client nickname.
+func (c *Client) setNick(nick string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nick = nick
+}
+
+// Send sends the given raw lines to the client.
+func (c *Client) Send(lines ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.queue = append(c.queue, lines...)
+	c.cond.Signal()
+}
+
+// Numeric sends a numeric reply to the client, where the
+// last of the given parameters is the trailing one.
+func (c *Client) Numeric(code string, params ...string) {
+	nick := c.Nick()
+	if nick == "" {
+		nick = "*"
+	}
+
+	line := ":" + ServerName + " " + code + " " + nick
+	if n := len(params); n > 0 {
+		if n > 1 {
+			line += " " + strings.Join(params[:n-1], " ")
+		}
+		line += " :" + params[n-1]
+	}
+	c.Send(line)
+}
+
+// Close closes the connection, after sending the lines
+// already queued.
+func (c *Client) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	c.cond.Signal()
+}
+
+// write writes the queued lines to the connection, until
+// the client is closed or the connection fails.
+func (c *Client) write() {
+	defer c.conn.Close()
+
+	for {
+		c.mu.Lock()
+		for len(c.queue) == 0 && !c.closed {
+			c.cond.Wait()
+		}
+		lines := c.queue
+		c.queue = nil
+		closed := c.closed
+		c.mu.Unlock()
+
+		for _, line := range lines {
+			if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+				c.Close()
+				return
+			}
+		}
+		if closed {
+			return
+		}
+	}
+}
+
+// prefix returns the prefix of the client.
+func (c *Client) prefix() irc.Prefix {
+	return irc.Prefix{Nick: c.Nick(), User: "~" + c.user, Host: "clients.irc.test"}
+}
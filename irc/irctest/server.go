@@ -0,0 +1,580 @@
+// Package irctest provides an in-memory IRC server for end-to-end
+// tests of IRC clients and bots.
+package irctest
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caiofilipini/got/irc"
+)
+
+// ServerName is the name the server uses as the prefix
+// of its own messages.
+const ServerName = "irc.test"
+
+// DefaultTimeout is how long WaitFor waits for a message.
+const DefaultTimeout = 2 * time.Second
+
+// ErrTimeout is returned by WaitFor when no matching
+// message is received in time.
+var ErrTimeout = errors.New("irctest: timed out waiting for message")
+
+// Handler handles a message sent by a client, before the server
+// does. It returns true if the message was handled, in which case
+// the server ignores it.
+type Handler func(c *Client, msg irc.Message) bool
+
+// Server is an in-memory IRC server. It handles the registration
+// (including the capability negotiation), channels, PRIVMSG and
+// NOTICE routing, and PING, and records every message sent by
+// clients. Handlers can be added to script the server replies.
+type Server struct {
+	// The dialer clients use to connect to the server.
+	pipe *irc.PipeDialer
+
+	// Guards the fields below.
+	mu sync.Mutex
+
+	// The connected clients.
+	clients map[*Client]bool
+
+	// A map where the key is the lowercased channel name,
+	// and the value is the channel.
+	channels map[string]*channel
+
+	// A map where the key is the lowercased nickname of
+	// a virtual user, and the value is the nickname.
+	users map[string]string
+
+	// The capabilities supported by the server.
+	caps []string
+
+	// A map where the key is a command, and the value is
+	// the handlers registered for it.
+	handlers map[string][]Handler
+
+	// The messages sent by clients, and whether each
+	// one has been returned by WaitFor.
+	received []irc.Message
+	consumed []bool
+
+	// Closed and replaced every time a message is received.
+	notify chan struct{}
+
+	// Tracks the goroutines serving the clients.
+	wg sync.WaitGroup
+}
+
+// channel holds the members of a channel.
+type channel struct {
+	// The channel name, as first joined.
+	name string
+
+	// A map where the key is the lowercased nickname, and
+	// the value is the nickname with its membership prefix.
+	members map[string]string
+}
+
+// NewServer creates a server and starts accepting connections.
+func NewServer() *Server {
+	s := &Server{
+		pipe:     irc.NewPipeDialer(),
+		clients:  make(map[*Client]bool),
+		channels: make(map[string]*channel),
+		users:    make(map[string]string),
+		handlers: make(map[string][]Handler),
+		notify:   make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s
+}
+
+// Dialer returns the dialer clients use to connect to the server.
+func (s *Server) Dialer() irc.Dialer {
+	return s.pipe
+}
+
+// Config returns a client configuration pointing to the server,
+// with flood control disabled and short reconnection delays.
+func (s *Server) Config() irc.Config {
+	return irc.Config{
+		Server:              ServerName,
+		Port:                6667,
+		Dialer:              s.pipe,
+		ReconnectDelay:      10 * time.Millisecond,
+		FloodInterval:       -1,
+		CTCPFloodInterval:   -1,
+		NickReclaimInterval: -1,
+	}
+}
+
+// Close disconnects all clients and stops accepting connections.
+func (s *Server) Close() {
+	s.pipe.Close()
+	s.Disconnect()
+	s.wg.Wait()
+}
+
+// SetCapabilities sets the capabilities the server supports.
+func (s *Server) SetCapabilities(caps ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.caps = caps
+}
+
+// Handle registers a handler for the messages with the given
+// command sent by clients, which is called before the server
+// handles them.
+func (s *Server) Handle(command string, h Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	command = strings.ToUpper(command)
+	s.handlers[command] = append(s.handlers[command], h)
+}
+
+// AddUser adds a virtual user with the given nickname,
+// who joins the given channels.
+func (s *Server) AddUser(nick string, channels ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.users[strings.ToLower(nick)] = nick
+	for _, name := range channels {
+		s.join(prefix(nick), name)
+	}
+}
+
+// Say sends a PRIVMSG from the given virtual user to the
+// given target, which can be either a channel or a client.
+func (s *Server) Say(from, target, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.route(nil, fmt.Sprintf(":%s PRIVMSG %s :%s", prefix(from), target, text), target)
+}
+
+// Send sends the given raw lines to all clients.
+func (s *Server) Send(lines ...string) {
+	for _, c := range s.Clients() {
+		c.Send(lines...)
+	}
+}
+
+// Disconnect closes the connections of all clients, which
+// may then reconnect.
+func (s *Server) Disconnect() {
+	for _, c := range s.Clients() {
+		c.Close()
+	}
+}
+
+// Clients returns the connected clients.
+func (s *Server) Clients() []*Client {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var clients []*Client
+	for c := range s.clients {
+		clients = append(clients, c)
+	}
+	return clients
+}
+
+// Members returns the nicknames of the members of the given
+// channel, with their membership prefixes, sorted.
+func (s *Server) Members(name string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var members []string
+	if ch := s.channels[strings.ToLower(name)]; ch != nil {
+		for _, m := range ch.members {
+			members = append(members, m)
+		}
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Received returns all the messages sent by clients so far.
+func (s *Server) Received() []irc.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]irc.Message{}, s.received...)
+}
+
+// WaitFor waits for a client to send a message that matches the
+// given filter, returning the first one not returned before. The
+// message has been handled by the server by then. It returns
+// ErrTimeout if there is none within DefaultTimeout.
+func (s *Server) WaitFor(filter irc.Filter) (irc.Message, error) {
+	timeout := time.After(DefaultTimeout)
+
+	for {
+		s.mu.Lock()
+		for i, msg := range s.received {
+			if !s.consumed[i] && filter(msg) {
+				s.consumed[i] = true
+				s.mu.Unlock()
+				return msg, nil
+			}
+		}
+		notify := s.notify
+		s.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-timeout:
+			return irc.Message{}, ErrTimeout
+		}
+	}
+}
+
+// accept serves the connections made with the server dialer.
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.pipe.Accept()
+		if err != nil {
+			return
+		}
+
+		c := newClient(s, conn)
+		s.mu.Lock()
+		s.clients[c] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(c)
+	}
+}
+
+// serve reads and handles the messages sent by the given
+// client, until it disconnects.
+func (s *Server) serve(c *Client) {
+	defer s.wg.Done()
+	defer s.disconnected(c)
+
+	buf := bufio.NewReader(c.conn)
+	for {
+		line, err := buf.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		msg, err := irc.ParseMessage(line)
+		if err != nil {
+			continue
+		}
+
+		s.mu.Lock()
+		handlers := s.handlers[msg.Command]
+		s.mu.Unlock()
+
+		if !s.script(handlers, c, msg) {
+			s.handle(c, msg)
+		}
+		s.record(msg)
+	}
+}
+
+// record records a message sent by a client, once handled,
+// and wakes up WaitFor.
+func (s *Server) record(msg irc.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.received = append(s.received, msg)
+	s.consumed = append(s.consumed, false)
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// script calls the given handlers, and reports whether
+// any of them handled the message.
+func (s *Server) script(handlers []Handler, c *Client, msg irc.Message) bool {
+	for _, h := range handlers {
+		if h(c, msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// disconnected removes the given client, which leaves
+// all its channels.
+func (s *Server) disconnected(c *Client) {
+	c.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.clients, c)
+	if c.registered {
+		s.quit(c, "Connection closed")
+	}
+}
+
+// handle handles a message sent by a client.
+func (s *Server) handle(c *Client, msg irc.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch msg.Command {
+	case "CAP":
+		s.handleCap(c, msg)
+	case "NICK":
+		s.handleNick(c, msg.Arg(0))
+	case "USER":
+		c.user = msg.Arg(0)
+		s.welcome(c)
+	case "PING":
+		c.Send(fmt.Sprintf(":%s PONG %s :%s", ServerName, ServerName, msg.Arg(0)))
+	case "JOIN":
+		if c.registered {
+			for _, name := range strings.Split(msg.Arg(0), ",") {
+				s.join(c.prefix(), name)
+			}
+		}
+	case "PART":
+		if c.registered {
+			for _, name := range strings.Split(msg.Arg(0), ",") {
+				s.part(c.prefix(), name, msg.Arg(1))
+			}
+		}
+	case "PRIVMSG", "NOTICE":
+		if c.registered {
+			line := fmt.Sprintf(":%s %s %s :%s", c.prefix(), msg.Command, msg.Arg(0), msg.Arg(1))
+			if !s.route(c, line, msg.Arg(0)) && msg.Command == "PRIVMSG" {
+				c.Numeric("401", msg.Arg(0), "No such nick/channel")
+			}
+		}
+	case "QUIT":
+		c.Send(fmt.Sprintf("ERROR :Closing Link: %s (Quit: %s)", c.Nick(), msg.Arg(0)))
+		s.quit(c, "Quit: "+msg.Arg(0))
+		c.registered = false
+		c.Close()
+	}
+}
+
+// handleCap handles the capability negotiation.
+func (s *Server) handleCap(c *Client, msg irc.Message) {
+	nick := c.Nick()
+	if nick == "" {
+		nick = "*"
+	}
+
+	switch strings.ToUpper(msg.Arg(0)) {
+	case "LS":
+		c.negotiating = !c.registered
+		c.Send(fmt.Sprintf(":%s CAP %s LS :%s", ServerName, nick, strings.Join(s.caps, " ")))
+	case "REQ":
+		requested := strings.Fields(msg.Arg(1))
+		for _, name := range requested {
+			if !s.supports(name) {
+				c.Send(fmt.Sprintf(":%s CAP %s NAK :%s", ServerName, nick, msg.Arg(1)))
+				return
+			}
+		}
+		for _, name := range requested {
+			c.caps[name] = true
+		}
+		c.Send(fmt.Sprintf(":%s CAP %s ACK :%s", ServerName, nick, msg.Arg(1)))
+	case "END":
+		c.negotiating = false
+		s.welcome(c)
+	}
+}
+
+// supports checks if the server supports the given capability.
+func (s *Server) supports(name string) bool {
+	for _, c := range s.caps {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// handleNick handles nickname changes, including the initial one.
+func (s *Server) handleNick(c *Client, nick string) {
+	if s.nickInUse(nick) && !strings.EqualFold(nick, c.Nick()) {
+		current := c.Nick()
+		if current == "" {
+			current = "*"
+		}
+		c.Send(fmt.Sprintf(":%s 433 %s %s :Nickname is already in use", ServerName, current, nick))
+		return
+	}
+
+	if !c.registered {
+		c.setNick(nick)
+		s.welcome(c)
+		return
+	}
+
+	line := fmt.Sprintf(":%s NICK :%s", c.prefix(), nick)
+	c.Send(line)
+	s.broadcast(c, line, c.Nick())
+
+	old := strings.ToLower(c.Nick())
+	for _, ch := range s.channels {
+		if m, ok := ch.members[old]; ok {
+			delete(ch.members, old)
+			ch.members[strings.ToLower(nick)] = strings.TrimSuffix(m, c.Nick()) + nick
+		}
+	}
+	c.setNick(nick)
+}
+
+// nickInUse checks if the given nickname is used by
+// a client or a virtual user.
+func (s *Server) nickInUse(nick string) bool {
+	if _, ok := s.users[strings.ToLower(nick)]; ok {
+		return true
+	}
+	return s.client(nick) != nil
+}
+
+// client returns the registered client with the given
+// nickname, if any.
+func (s *Server) client(nick string) *Client {
+	for c := range s.clients {
+		if c.Nick() != "" && strings.EqualFold(c.Nick(), nick) {
+			return c
+		}
+	}
+	return nil
+}
+
+// welcome completes the registration of the given client,
+// once it has sent both NICK and USER and the capability
+// negotiation, if any, has ended.
+func (s *Server) welcome(c *Client) {
+	if c.registered || c.negotiating || c.Nick() == "" || c.user == "" {
+		return
+	}
+	c.registered = true
+
+	c.Numeric("001", "Welcome to the test network "+c.prefix().String())
+	c.Numeric("005", "PREFIX=(ov)@+", "CHANMODES=beI,k,l,imnpst", "NETWORK=Test", "are supported by this server")
+	c.Numeric("422", "MOTD File is missing")
+}
+
+// join adds the user with the given prefix to the channel,
+// creating it if needed, and lets the members know. The client,
+// if any, receives the list of members.
+func (s *Server) join(p irc.Prefix, name string) {
+	key := strings.ToLower(name)
+
+	ch := s.channels[key]
+	member := p.Nick
+	if ch == nil {
+		ch = &channel{name: name, members: make(map[string]string)}
+		s.channels[key] = ch
+		member = "@" + p.Nick
+	}
+	if _, ok := ch.members[strings.ToLower(p.Nick)]; ok {
+		return
+	}
+	ch.members[strings.ToLower(p.Nick)] = member
+
+	s.route(nil, fmt.Sprintf(":%s JOIN %s", p, ch.name), ch.name)
+
+	if c := s.client(p.Nick); c != nil {
+		var names []string
+		for _, m := range ch.members {
+			names = append(names, m)
+		}
+		sort.Strings(names)
+		c.Numeric("353", "=", ch.name, strings.Join(names, " "))
+		c.Numeric("366", ch.name, "End of /NAMES list.")
+	}
+}
+
+// part removes the user with the given prefix from the channel,
+// letting the members know.
+func (s *Server) part(p irc.Prefix, name, reason string) {
+	ch := s.channels[strings.ToLower(name)]
+	if ch == nil {
+		return
+	}
+	if _, ok := ch.members[strings.ToLower(p.Nick)]; !ok {
+		return
+	}
+
+	s.route(nil, fmt.Sprintf(":%s PART %s :%s", p, ch.name, reason), ch.name)
+	delete(ch.members, strings.ToLower(p.Nick))
+}
+
+// quit removes the given client from all its channels,
+// letting the other members know.
+func (s *Server) quit(c *Client, reason string) {
+	s.broadcast(c, fmt.Sprintf(":%s QUIT :%s", c.prefix(), reason), c.Nick())
+
+	for _, ch := range s.channels {
+		delete(ch.members, strings.ToLower(c.Nick()))
+	}
+}
+
+// broadcast sends the given line to the clients, other than
+// the sender, sharing a channel with the given nickname.
+func (s *Server) broadcast(sender *Client, line, nick string) {
+	sent := map[*Client]bool{sender: true}
+
+	for _, ch := range s.channels {
+		if _, ok := ch.members[strings.ToLower(nick)]; !ok {
+			continue
+		}
+		for member := range ch.members {
+			if c := s.client(member); c != nil && !sent[c] {
+				c.Send(line)
+				sent[c] = true
+			}
+		}
+	}
+}
+
+// route sends the given line to the target, which can be either
+// a channel or a nickname, except to the sender. It reports
+// whether the target exists.
+func (s *Server) route(sender *Client, line, target string) bool {
+	if irc.IsChannel(target) {
+		ch := s.channels[strings.ToLower(target)]
+		if ch == nil {
+			return false
+		}
+		for member := range ch.members {
+			if c := s.client(member); c != nil && c != sender {
+				c.Send(line)
+			}
+		}
+		return true
+	}
+
+	if c := s.client(target); c != nil {
+		c.Send(line)
+		return true
+	}
+	_, ok := s.users[strings.ToLower(target)]
+	return ok
+}
+
+// prefix returns the prefix of the virtual user
+// with the given nickname.
+func prefix(nick string) irc.Prefix {
+	return irc.Prefix{Nick: nick, User: "~" + strings.ToLower(nick), Host: "users.irc.test"}
+}
@@ -0,0 +1,161 @@
+package irctest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiofilipini/got/irc"
+	"github.com/stretchr/testify/assert"
+)
+
+// connect connects a client to the given server and
+// registers it with the given nickname.
+func connect(t *testing.T, server *Server, config irc.Config, nick string) *irc.IRC {
+	conn, err := irc.NewIRC(config)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	t.Cleanup(conn.Close)
+
+	if !assert.Nil(t, conn.Join(nick)) {
+		t.FailNow()
+	}
+	return conn
+}
+
+func matchMessage(command, target, text string) irc.Filter {
+	return func(msg irc.Message) bool {
+		return msg.Command == command && msg.Arg(0) == target && msg.Trailing == text
+	}
+}
+
+func TestRegistration(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	conn := connect(t, server, server.Config(), "marvin")
+
+	assert.Equal(t, "marvin", conn.Nick())
+	if assert.Len(t, server.Clients(), 1) {
+		assert.Equal(t, "marvin", server.Clients()[0].Nick())
+	}
+}
+
+func TestRegistrationWithNickInUse(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.AddUser("marvin")
+
+	config := server.Config()
+	config.AltNicks = []string{"marvin2"}
+	conn := connect(t, server, config, "marvin")
+
+	assert.Equal(t, "marvin2", conn.Nick())
+}
+
+func TestCapabilityNegotiation(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.SetCapabilities(irc.CapMultiPrefix)
+
+	config := server.Config()
+	config.Capabilities = []string{irc.CapMultiPrefix, irc.CapAwayNotify}
+	conn := connect(t, server, config, "marvin")
+
+	assert.True(t, conn.HasCapability(irc.CapMultiPrefix))
+	assert.False(t, conn.HasCapability(irc.CapAwayNotify))
+}
+
+func TestChannelsAndRouting(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.AddUser("arthur", "#hhgg")
+
+	config := server.Config()
+	config.Channels = []irc.ChannelConfig{{Name: "#hhgg"}}
+	marvin, err := irc.NewIRC(config)
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer marvin.Close()
+
+	messages := make(chan irc.Message, 3)
+	marvin.Subscribe(irc.MatchCommand("366", "PRIVMSG"), messages)
+	if !assert.Nil(t, marvin.Join("marvin")) {
+		t.FailNow()
+	}
+
+	assert.Equal(t, "366", (<-messages).Command)
+	assert.Equal(t, []string{"@arthur", "marvin"}, server.Members("#hhgg"))
+	assert.Len(t, marvin.ChannelUsers("#hhgg"), 2)
+
+	server.Say("arthur", "#hhgg", "don't panic")
+	server.Say("arthur", "marvin", "towel?")
+	assert.Equal(t, "don't panic", (<-messages).Trailing)
+	assert.Equal(t, "towel?", (<-messages).Trailing)
+
+	marvin.SendMessages("arthur", "life. don't talk to me about life.")
+	_, err = server.WaitFor(matchMessage("PRIVMSG", "arthur", "life. don't talk to me about life."))
+	assert.Nil(t, err)
+}
+
+func TestScriptedReplies(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+	server.Handle("USER", func(c *Client, msg irc.Message) bool {
+		c.Numeric("465", "You are banned from this server")
+		return true
+	})
+
+	conn, err := irc.NewIRC(server.Config())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	err = conn.Join("marvin")
+	if assert.IsType(t, irc.RegistrationError{}, err) {
+		assert.Equal(t, "465", err.(irc.RegistrationError).Code)
+	}
+}
+
+func TestRegistrationAfterDisconnectWhileRegistering(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	var once sync.Once
+	server.Handle("USER", func(c *Client, msg irc.Message) bool {
+		dropped := false
+		once.Do(func() {
+			c.Close()
+			dropped = true
+		})
+		return dropped
+	})
+
+	conn, err := irc.NewIRC(server.Config())
+	if !assert.Nil(t, err) {
+		t.FailNow()
+	}
+	defer conn.Close()
+
+	result := make(chan error, 1)
+	go func() { result <- conn.Join("marvin") }()
+
+	select {
+	case err := <-result:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Join didn't return after reconnecting")
+	}
+	assert.Equal(t, "marvin", conn.Nick())
+}
+
+func TestWaitForTimesOut(t *testing.T) {
+	server := NewServer()
+	defer server.Close()
+
+	_, err := server.WaitFor(matchMessage("PRIVMSG", "#hhgg", "hello?"))
+	assert.Equal(t, ErrTimeout, err)
+}
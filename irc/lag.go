@@ -0,0 +1,148 @@
+package irc
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Default ping settings.
+const (
+	DefaultPingInterval = 1 * time.Minute
+	DefaultPingTimeout  = 3 * time.Minute
+)
+
+// The prefix of the tokens sent with the client PINGs,
+// followed by the time they were sent.
+const pingTokenPrefix = "got-"
+
+// lag keeps track of the PINGs sent to the server, in order to
+// measure the round-trip lag and detect dead connections.
+type lag struct {
+	sync.Mutex
+
+	// The token of the PING waiting for a PONG, if any.
+	token string
+
+	// When the PING waiting for a PONG was sent.
+	sent time.Time
+
+	// The last lag measured, and whether there is one.
+	last     time.Duration
+	measured bool
+}
+
+// reset forgets the PING waiting for a PONG, if any,
+// after connecting again.
+func (l *lag) reset() {
+	l.Lock()
+	defer l.Unlock()
+
+	l.token = ""
+}
+
+// ping returns the token of a new PING, unless there is one
+// waiting for a PONG already. If the PING waiting has been
+// waiting longer than the given timeout, it returns false.
+func (l *lag) ping(now time.Time, timeout time.Duration) (string, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.token != "" {
+		return "", now.Sub(l.sent) < timeout
+	}
+
+	l.token = pingTokenPrefix + strconv.FormatInt(now.UnixNano(), 10)
+	l.sent = now
+	return l.token, true
+}
+
+// handle measures the lag when the server replies
+// to the PING waiting for a PONG.
+func (l *lag) handle(msg Message) {
+	if msg.Command != "PONG" {
+		return
+	}
+
+	args := msg.Args()
+	if len(args) == 0 {
+		return
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	if l.token == "" || args[len(args)-1] != l.token {
+		return
+	}
+	l.last, l.measured = time.Since(l.sent), true
+	l.token = ""
+}
+
+// current returns the lag: the last one measured, or how long the
+// PING waiting for a PONG has been waiting, whichever is longer.
+func (l *lag) current() (time.Duration, bool) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.token != "" {
+		if waiting := time.Since(l.sent); waiting > l.last {
+			return waiting, true
+		}
+	}
+	return l.last, l.measured
+}
+
+// Lag returns the round-trip time of the PINGs the client sends
+// to the server, and false if none has been measured yet. While
+// a PONG is overdue, it returns how long it has been waiting.
+func (irc *IRC) Lag() (time.Duration, bool) {
+	return irc.lag.current()
+}
+
+// handleLag sends PINGs to the server on the configured
+// interval, once registered, and drops the connection if
+// a PONG doesn't arrive within the configured timeout,
+// so that the client reconnects.
+func (irc *IRC) handleLag() {
+	defer irc.wg.Done()
+
+	interval, timeout := irc.config.PingInterval, irc.config.PingTimeout
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = DefaultPingInterval
+	}
+	if timeout <= 0 {
+		timeout = DefaultPingTimeout
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			irc.mu.RLock()
+			registered, conn := irc.registered, irc.conn
+			irc.mu.RUnlock()
+
+			if !registered {
+				continue
+			}
+
+			token, alive := irc.lag.ping(now, timeout)
+			if !alive {
+				log.Printf("[IRC] Ping timeout: no reply from the server in %s, dropping connection\n", timeout)
+				irc.lag.reset()
+				conn.Close()
+			} else if token != "" {
+				irc.queuePriority("PING :" + token)
+			}
+		case <-irc.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// expectPing reads lines sent by the client until a PING,
+// and returns its token.
+func (s *testServer) expectPing() string {
+	s.conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	for {
+		line, err := s.reader.ReadString('\n')
+		if !assert.Nil(s.t, err, "expected PING") {
+			return ""
+		}
+		if strings.HasPrefix(line, "PING :") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "PING :"))
+		}
+	}
+}
+
+func TestLagPings(t *testing.T) {
+	l := &lag{}
+	now := time.Now()
+
+	token, alive := l.ping(now, time.Minute)
+	assert.True(t, alive)
+	assert.True(t, strings.HasPrefix(token, pingTokenPrefix))
+
+	// No new PING is sent until the server replies.
+	next, alive := l.ping(now.Add(30*time.Second), time.Minute)
+	assert.True(t, alive)
+	assert.Equal(t, "", next)
+
+	_, alive = l.ping(now.Add(time.Minute), time.Minute)
+	assert.False(t, alive)
+
+	l.reset()
+	next, alive = l.ping(now.Add(2*time.Minute), time.Minute)
+	assert.True(t, alive)
+	assert.NotEqual(t, token, next)
+}
+
+func TestLagIsMeasured(t *testing.T) {
+	irc, server := newTestIRC(t, Config{PingInterval: 20 * time.Millisecond})
+	result := register(irc, server, "got")
+	server.welcome("got")
+	assert.Nil(t, <-result)
+
+	_, measured := irc.Lag()
+	assert.False(t, measured)
+
+	token := server.expectPing()
+	time.Sleep(5 * time.Millisecond)
+
+	// A PONG with an unexpected token is ignored.
+	server.send(":irc.example.com PONG irc.example.com :whatever")
+	server.send(":irc.example.com PONG irc.example.com :" + token)
+	server.expectPing()
+
+	lag, measured := irc.Lag()
+	assert.True(t, measured)
+	assert.True(t, lag >= 5*time.Millisecond, "lag: %s", lag)
+}
+
+func TestPingTimeoutReconnects(t *testing.T) {
+	listener, config := newTestListener(t)
+	config.PingInterval = 10 * time.Millisecond
+	config.PingTimeout = 30 * time.Millisecond
+
+	irc, err := NewIRC(config)
+	assert.Nil(t, err)
+	defer irc.Close()
+
+	disconnected := make(chan error, 1)
+	irc.OnDisconnect(func(err error) { disconnected <- err })
+
+	server := accept(t, listener)
+	result := register(irc, server, "got")
+	server.welcome("got")
+	assert.Nil(t, <-result)
+
+	// The server goes silent.
+	server.expectPing()
+	assert.NotNil(t, <-disconnected)
+
+	server = accept(t, listener)
+	server.expect("CAP LS 302")
+}
+
+func TestPingDisabled(t *testing.T) {
+	irc, server := newTestIRC(t, Config{PingInterval: -1})
+	result := register(irc, server, "got")
+	server.welcome("got")
+	assert.Nil(t, <-result)
+
+	time.Sleep(20 * time.Millisecond)
+	server.sync()
+
+	_, measured := irc.Lag()
+	assert.False(t, measured)
+}
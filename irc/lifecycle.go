@@ -0,0 +1,182 @@
+package irc
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// DefaultQuitMessage is the default reason sent
+// with QUIT when shutting down.
+const DefaultQuitMessage = "KTHXBAI."
+
+// How many replies to messages received from the server can
+// wait to be queued; the ones sent past that are dropped.
+const maxPendingReplies = 16
+
+// How long to wait for the server to close the connection
+// after QUIT, unless the shutdown context expires earlier.
+const quitTimeout = 5 * time.Second
+
+// Run blocks until the given context is done, returning its
+// error, or until a failure is reported (see Errors), returning
+// it. Either way, the client is left as is: it's up to the caller
+// to Reconnect and Run again, or to Shutdown.
+func (irc *IRC) Run(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case err := <-irc.errors:
+		return err
+	}
+}
+
+// Shutdown gracefully shuts the client down: it stops accepting
+// messages for sending, waits for the pending ones to be sent,
+// sends QUIT with the configured reason and waits for the server
+// to close the connection, then closes the client. If the given
+// context is done first, the client is closed right away and the
+// context error is returned.
+func (irc *IRC) Shutdown(ctx context.Context) error {
+	defer irc.Close()
+
+	irc.mu.Lock()
+	closing, failed := irc.closing, irc.failed
+	irc.closing = true
+	irc.mu.Unlock()
+
+	if closing {
+		return nil
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		irc.pending.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if failed {
+		return nil
+	}
+	return irc.quit(ctx)
+}
+
+// quit sends QUIT, after any messages queued before it, and waits
+// for the server to close the connection.
+func (irc *IRC) quit(ctx context.Context) error {
+	reason := irc.config.QuitMessage
+	if reason == "" {
+		reason = DefaultQuitMessage
+	}
+
+	select {
+	case irc.out <- "QUIT :" + reason:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	select {
+	case <-irc.stopped:
+	case <-time.After(quitTimeout):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	return nil
+}
+
+// Close closes the connection right away, without sending QUIT
+// or waiting for pending messages to be sent (see Shutdown), and
+// waits for the goroutines handling the connection to finish.
+// The subscriptions are cancelled afterwards, closing their
+// channels, and the event handlers are removed.
+func (irc *IRC) Close() {
+	irc.closeOnce.Do(func() {
+		irc.mu.Lock()
+		irc.closing = true
+		irc.mu.Unlock()
+
+		close(irc.done)
+		irc.connection().Close()
+		irc.wg.Wait()
+
+		for _, s := range irc.subscriptions.all() {
+			s.Unsubscribe()
+		}
+		irc.events.removeAll()
+	})
+}
+
+// sending tracks a message being queued for sending through the
+// public API, so that Shutdown waits for it. It returns false if
+// the client is shutting down, in which case the message should
+// be dropped; otherwise, pending.Done must be called once queued.
+func (irc *IRC) sending() bool {
+	irc.mu.Lock()
+	defer irc.mu.Unlock()
+
+	if irc.closing {
+		return false
+	}
+	irc.pending.Add(1)
+	return true
+}
+
+// isClosing checks if the client is shutting down.
+func (irc *IRC) isClosing() bool {
+	irc.mu.RLock()
+	defer irc.mu.RUnlock()
+
+	return irc.closing
+}
+
+// queue queues the given message for sending, subject to flood
+// control. The message is dropped if the client is closed.
+func (irc *IRC) queue(msg string) {
+	select {
+	case irc.out <- msg:
+	case <-irc.done:
+	}
+}
+
+// reply queues the given message, sent in reply to one received
+// from the server, without waiting for flood control, so that the
+// reader isn't held up. The message is dropped if too many replies
+// are waiting to be queued already.
+func (irc *IRC) reply(msg string) {
+	select {
+	case irc.replies <- msg:
+	default:
+		log.Printf("[IRC] Too many replies waiting, dropping %q\n", msg)
+	}
+}
+
+// handleReplies queues the replies sent with reply for
+// sending, in order, until the client is closed.
+func (irc *IRC) handleReplies() {
+	defer irc.wg.Done()
+
+	for {
+		select {
+		case msg := <-irc.replies:
+			irc.queue(msg)
+		case <-irc.done:
+			return
+		}
+	}
+}
+
+// queuePriority queues the given message for sending ahead of
+// the ones queued with queue, bypassing flood control. The message
+// is dropped if the client is closed.
+func (irc *IRC) queuePriority(msg string) {
+	select {
+	case irc.priority <- msg:
+	case <-irc.done:
+	}
+}
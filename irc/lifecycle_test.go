@@ -0,0 +1,89 @@
+package irc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdownDrainsPendingMessages(t *testing.T) {
+	irc, server := newTestIRC(t, Config{FloodBurst: 1, FloodInterval: 20 * time.Millisecond, QuitMessage: "so long"})
+
+	go irc.SendMessages("#got", "one", "two", "three")
+	server.expect("PRIVMSG #got :one")
+
+	result := make(chan error)
+	go func() { result <- irc.Shutdown(context.Background()) }()
+
+	server.expect("PRIVMSG #got :two")
+	server.expect("PRIVMSG #got :three")
+	server.expect("QUIT :so long")
+	server.conn.Close()
+
+	assert.Nil(t, <-result)
+}
+
+func TestShutdownDropsMessagesAfterwards(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+
+	result := make(chan error)
+	go func() { result <- irc.Shutdown(context.Background()) }()
+
+	server.expect("QUIT :" + DefaultQuitMessage)
+	server.conn.Close()
+	assert.Nil(t, <-result)
+
+	irc.SendMessages("#got", "anyone there?")
+	irc.JoinChannel("#beer", "")
+	assert.Nil(t, irc.Shutdown(context.Background()))
+}
+
+func TestShutdownGivesUpWhenContextExpires(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	result := make(chan error)
+	go func() { result <- irc.Shutdown(ctx) }()
+
+	server.expect("QUIT :" + DefaultQuitMessage)
+	assert.Equal(t, context.DeadlineExceeded, <-result)
+}
+
+func TestCloseClosesSubscriptions(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+
+	messages := make(chan Message)
+	irc.Subscribe(MatchCommand("PRIVMSG"), messages)
+
+	// Nobody reads the subscription, so the reader blocks.
+	server.send(":marvin!~marvin@hhgg.org PRIVMSG #got :hello?")
+
+	irc.Close()
+	irc.Close()
+
+	_, ok := <-messages
+	assert.False(t, ok)
+}
+
+func TestRunReturnsWhenContextIsDone(t *testing.T) {
+	irc, _ := newTestIRC(t, Config{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, irc.Run(ctx))
+}
+
+func TestRunReturnsFailures(t *testing.T) {
+	irc, _ := newTestIRC(t, Config{})
+
+	failure := errors.New("irc: giving up")
+	go irc.fail(failure)
+
+	assert.Equal(t, failure, irc.Run(context.Background()))
+}
@@ -0,0 +1,283 @@
+package irc
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Prefix represents the origin of a message, as described
+// in RFC 2812 (section 2.3.1). For messages originating from
+// a server, only Nick is set, containing the server name.
+type Prefix struct {
+	// The nickname (or server name) of the origin.
+	Nick string
+
+	// The username of the origin, if present.
+	User string
+
+	// The hostname of the origin, if present.
+	Host string
+}
+
+// String formats the prefix as nick!user@host.
+func (p Prefix) String() string {
+	s := p.Nick
+	if p.User != "" {
+		s += "!" + p.User
+	}
+	if p.Host != "" {
+		s += "@" + p.Host
+	}
+	return s
+}
+
+// Message represents a single parsed IRC message.
+type Message struct {
+	// The IRCv3 message tags, if any.
+	Tags map[string]string
+
+	// The origin of the message; empty for messages
+	// sent by the client.
+	Prefix Prefix
+
+	// The command name (e.g. "PRIVMSG") or the
+	// three-digit numeric reply (e.g. "001").
+	Command string
+
+	// The middle parameters, excluding the trailing one.
+	Params []string
+
+	// The trailing parameter, i.e. the one preceded by ":".
+	Trailing string
+
+	// Whether the message has a trailing parameter, which
+	// tells an empty one (e.g. "TOPIC #got :", clearing the
+	// topic) from a missing one (e.g. "TOPIC #got").
+	HasTrailing bool
+}
+
+// ErrEmptyMessage is returned when parsing an empty line.
+var ErrEmptyMessage = errors.New("irc: empty message")
+
+// ErrNoCommand is returned when parsing a line that
+// does not contain a command.
+var ErrNoCommand = errors.New("irc: message has no command")
+
+// ParseMessage parses a raw line, as received from the server,
+// into a Message. The trailing "\r\n", if present, is ignored.
+func ParseMessage(line string) (Message, error) {
+	var msg Message
+
+	line = strings.TrimRight(line, "\r\n")
+	if strings.TrimSpace(line) == "" {
+		return msg, ErrEmptyMessage
+	}
+
+	if line[0] == '@' {
+		var tags string
+		tags, line = split(line[1:])
+		msg.Tags = parseTags(tags)
+	}
+
+	if line != "" && line[0] == ':' {
+		var prefix string
+		prefix, line = split(line[1:])
+		msg.Prefix = ParsePrefix(prefix)
+	}
+
+	msg.Command, line = split(line)
+	if msg.Command == "" {
+		return msg, ErrNoCommand
+	}
+	msg.Command = strings.ToUpper(msg.Command)
+
+	for line != "" {
+		if line[0] == ':' {
+			msg.Trailing, msg.HasTrailing = line[1:], true
+			break
+		}
+
+		var param string
+		param, line = split(line)
+		msg.Params = append(msg.Params, param)
+	}
+
+	return msg, nil
+}
+
+// ParsePrefix parses a prefix in the form nick!user@host.
+// The user and host parts are optional.
+func ParsePrefix(prefix string) Prefix {
+	var p Prefix
+
+	if i := strings.IndexByte(prefix, '@'); i >= 0 {
+		p.Host = prefix[i+1:]
+		prefix = prefix[:i]
+	}
+	if i := strings.IndexByte(prefix, '!'); i >= 0 {
+		p.User = prefix[i+1:]
+		prefix = prefix[:i]
+	}
+	p.Nick = prefix
+
+	return p
+}
+
+// Args returns all the message parameters, including the
+// trailing one, if present.
+func (m Message) Args() []string {
+	args := append([]string{}, m.Params...)
+	if m.hasTrailing() {
+		args = append(args, m.Trailing)
+	}
+	return args
+}
+
+// Arg returns the i-th parameter of the message, taking the
+// trailing parameter into account, or an empty string if
+// there is no such parameter.
+func (m Message) Arg(i int) string {
+	if args := m.Args(); i >= 0 && i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// Time returns the time the message was sent, as given by
+// the server-time capability, or the current time if the
+// server did not provide it.
+func (m Message) Time() time.Time {
+	if t, err := time.Parse(time.RFC3339Nano, m.Tags["time"]); err == nil {
+		return t
+	}
+	return time.Now()
+}
+
+// Account returns the account name of the sender, as given
+// by the account-tag capability, if present.
+func (m Message) Account() string {
+	return m.Tags["account"]
+}
+
+// String formats the message into its wire representation,
+// without the trailing "\r\n".
+func (m Message) String() string {
+	var parts []string
+
+	if len(m.Tags) > 0 {
+		parts = append(parts, "@"+formatTags(m.Tags))
+	}
+	if m.Prefix.Nick != "" {
+		parts = append(parts, ":"+m.Prefix.String())
+	}
+
+	parts = append(parts, m.Command)
+	parts = append(parts, m.Params...)
+
+	if m.hasTrailing() {
+		parts = append(parts, ":"+m.Trailing)
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// hasTrailing checks if the message has a trailing parameter,
+// including an empty one.
+func (m Message) hasTrailing() bool {
+	return m.HasTrailing || m.Trailing != ""
+}
+
+// split returns the first space-delimited token of the given
+// string, and the rest of the string with leading spaces removed.
+func split(s string) (string, string) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimLeft(s[i+1:], " ")
+}
+
+// tagEscapes maps the characters that follow a backslash in
+// the escape sequences used in tag values to the characters
+// they represent.
+var tagEscapes = map[byte]byte{
+	':':  ';',
+	's':  ' ',
+	'\\': '\\',
+	'r':  '\r',
+	'n':  '\n',
+}
+
+// tagUnescapes does the opposite of tagEscapes.
+var tagUnescapes = strings.NewReplacer(
+	";", `\:`,
+	" ", `\s`,
+	`\`, `\\`,
+	"\r", `\r`,
+	"\n", `\n`,
+)
+
+// parseTags parses the IRCv3 tags section of a message.
+func parseTags(tags string) map[string]string {
+	parsed := make(map[string]string)
+
+	for _, tag := range strings.Split(tags, ";") {
+		if tag == "" {
+			continue
+		}
+		if i := strings.IndexByte(tag, '='); i >= 0 {
+			parsed[tag[:i]] = unescapeTag(tag[i+1:])
+		} else {
+			parsed[tag] = ""
+		}
+	}
+
+	return parsed
+}
+
+// unescapeTag replaces the escape sequences in the given tag value
+// with the characters they represent. As per the IRCv3 spec, the
+// backslash is dropped from unknown sequences (e.g. "\x" is "x"),
+// as well as at the end of the value.
+func unescapeTag(value string) string {
+	if strings.IndexByte(value, '\\') < 0 {
+		return value
+	}
+
+	var sb strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '\\' {
+			if i++; i == len(value) {
+				break
+			}
+			c = value[i]
+			if unescaped, ok := tagEscapes[c]; ok {
+				c = unescaped
+			}
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// formatTags formats the given tags into their wire representation.
+func formatTags(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, k := range keys {
+		if v := tags[k]; v == "" {
+			pairs = append(pairs, k)
+		} else {
+			pairs = append(pairs, k+"="+tagUnescapes.Replace(v))
+		}
+	}
+	return strings.Join(pairs, ";")
+}
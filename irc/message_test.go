@@ -0,0 +1,119 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMessage(t *testing.T) {
+	msg, err := ParseMessage(":marvin!~marvin@example.com PRIVMSG #got :!got greet arthur\r\n")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Prefix{"marvin", "~marvin", "example.com"}, msg.Prefix)
+	assert.Equal(t, "PRIVMSG", msg.Command)
+	assert.Equal(t, []string{"#got"}, msg.Params)
+	assert.Equal(t, "!got greet arthur", msg.Trailing)
+}
+
+func TestParseMessageWithoutPrefix(t *testing.T) {
+	msg, err := ParseMessage("PING :irc.example.com")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Prefix{}, msg.Prefix)
+	assert.Equal(t, "PING", msg.Command)
+	assert.Equal(t, "irc.example.com", msg.Arg(0))
+}
+
+func TestParseMessageWithServerPrefix(t *testing.T) {
+	msg, err := ParseMessage(":irc.example.com 001 gotgotgot :Welcome to the network")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Prefix{Nick: "irc.example.com"}, msg.Prefix)
+	assert.Equal(t, "001", msg.Command)
+	assert.Equal(t, []string{"gotgotgot", "Welcome to the network"}, msg.Args())
+}
+
+func TestParseMessageWithTags(t *testing.T) {
+	msg, err := ParseMessage(`@time=2015-01-01T00:00:00.000Z;account=marvin;+draft/x=a\sb\:c;flag :marvin PRIVMSG #got :hi`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "2015-01-01T00:00:00.000Z", msg.Tags["time"])
+	assert.Equal(t, "marvin", msg.Tags["account"])
+	assert.Equal(t, "a b;c", msg.Tags["+draft/x"])
+	assert.Contains(t, msg.Tags, "flag")
+	assert.Equal(t, "marvin", msg.Prefix.Nick)
+}
+
+func TestParseMessageWithEscapedTags(t *testing.T) {
+	msg, err := ParseMessage(`@a=b\xc;b=\\s\r\n;c=trailing\ :marvin PRIVMSG #got :hi`)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "bxc", msg.Tags["a"])
+	assert.Equal(t, "\\s\r\n", msg.Tags["b"])
+	assert.Equal(t, "trailing", msg.Tags["c"])
+}
+
+func TestParseMessageWithNickContainingPing(t *testing.T) {
+	msg, err := ParseMessage(":PINGer!u@h PRIVMSG #got :PING me")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "PRIVMSG", msg.Command)
+	assert.Equal(t, "PINGer", msg.Prefix.Nick)
+}
+
+func TestParseMessageWithEmptyTrailing(t *testing.T) {
+	msg, err := ParseMessage("MODE #got +o marvin")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"#got", "+o", "marvin"}, msg.Params)
+	assert.Equal(t, "", msg.Trailing)
+	assert.Equal(t, "", msg.Arg(3))
+	assert.Equal(t, "", msg.Arg(-1))
+}
+
+func TestParseMessageErrors(t *testing.T) {
+	_, err := ParseMessage("\r\n")
+	assert.Equal(t, ErrEmptyMessage, err)
+
+	_, err = ParseMessage(":irc.example.com")
+	assert.Equal(t, ErrNoCommand, err)
+}
+
+func TestMessageString(t *testing.T) {
+	line := "@account=marvin :marvin!~marvin@example.com PRIVMSG #got :hello there"
+	msg, _ := ParseMessage(line)
+
+	assert.Equal(t, line, msg.String())
+}
+
+func TestMessageStringWithEmptyTrailing(t *testing.T) {
+	msg, _ := ParseMessage(":marvin TOPIC #got :")
+	assert.Equal(t, ":marvin TOPIC #got :", msg.String())
+	assert.Equal(t, []string{"#got", ""}, msg.Args())
+
+	msg, _ = ParseMessage(":marvin TOPIC #got")
+	assert.Equal(t, ":marvin TOPIC #got", msg.String())
+	assert.Equal(t, []string{"#got"}, msg.Args())
+}
+
+func TestMatchCommand(t *testing.T) {
+	f := MatchCommand("PRIVMSG", "NOTICE")
+
+	assert.True(t, f(Message{Command: "PRIVMSG"}))
+	assert.True(t, f(Message{Command: "NOTICE"}))
+	assert.False(t, f(Message{Command: "JOIN"}))
+}
+
+func TestMessageTime(t *testing.T) {
+	msg, _ := ParseMessage("@time=2015-03-14T09:26:53.589Z :marvin PRIVMSG #got :hi")
+
+	assert.Equal(t, time.Date(2015, 3, 14, 9, 26, 53, 589000000, time.UTC), msg.Time())
+}
+
+func TestMessageAccount(t *testing.T) {
+	msg, _ := ParseMessage("@account=marvin :m PRIVMSG #got :hi")
+
+	assert.Equal(t, "marvin", msg.Account())
+}
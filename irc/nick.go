@@ -0,0 +1,170 @@
+package irc
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// Numeric replies sent by the server when the
+// nickname can't be used.
+const (
+	errErroneousNickname = "432"
+	errNicknameInUse     = "433"
+	errNickCollision     = "436"
+	errUnavailResource   = "437"
+)
+
+// DefaultNickReclaimInterval is how often the client tries
+// to reclaim its primary nickname, if it's using a fallback.
+const DefaultNickReclaimInterval = 5 * time.Minute
+
+// The maximum number of underscores appended to the primary
+// nickname when all the fallback nicknames are taken.
+const maxNickSuffix = 5
+
+// Nick returns the nickname currently used by the client.
+func (irc *IRC) Nick() string {
+	irc.mu.RLock()
+	defer irc.mu.RUnlock()
+
+	return irc.self.Nick
+}
+
+// fallbackNick returns the nickname to try after the given
+// number of failed attempts: the configured fallback nicknames
+// first, then the primary one followed by underscores. It returns
+// an empty string if there are no nicknames left to try.
+func (irc *IRC) fallbackNick(attempt int) string {
+	if attempt <= len(irc.config.AltNicks) {
+		return irc.config.AltNicks[attempt-1]
+	}
+	if suffix := attempt - len(irc.config.AltNicks); suffix <= maxNickSuffix {
+		return irc.user + strings.Repeat("_", suffix)
+	}
+	return ""
+}
+
+// handleNick reacts to the messages related to the client
+// nickname: it tries the fallback nicknames while registering,
+// identifies with NickServ once registered, and reclaims the
+// primary nickname as soon as it becomes available.
+func (irc *IRC) handleNick(msg Message) {
+	irc.mu.RLock()
+	registered, primary, current := irc.registered, irc.user, irc.self.Nick
+	irc.mu.RUnlock()
+
+	switch msg.Command {
+	case errErroneousNickname, errNicknameInUse, errNickCollision, errUnavailResource:
+		if registered {
+			return
+		}
+
+		irc.mu.Lock()
+		irc.nickAttempts++
+		next := irc.fallbackNick(irc.nickAttempts)
+		irc.self.Nick = next
+		irc.mu.Unlock()
+
+		if next == "" {
+			log.Printf("[IRC] Nickname %s unavailable and no fallbacks left\n", msg.Arg(1))
+			irc.registration.finish(ErrNoNickAvailable)
+			return
+		}
+		log.Printf("[IRC] Nickname %s unavailable, trying %s\n", msg.Arg(1), next)
+		irc.queuePriority("NICK " + next)
+	case rplWelcome:
+		irc.mu.Lock()
+		irc.registered = true
+		irc.mu.Unlock()
+
+		if strings.EqualFold(msg.Arg(0), primary) {
+			irc.identify()
+		} else {
+			irc.recoverNick()
+		}
+	case "NICK":
+		if strings.EqualFold(msg.Arg(0), primary) && strings.EqualFold(msg.Prefix.Nick, current) {
+			irc.identify()
+		} else if strings.EqualFold(msg.Prefix.Nick, primary) && !strings.EqualFold(current, primary) {
+			irc.reclaimNick()
+		}
+	case "QUIT":
+		if strings.EqualFold(msg.Prefix.Nick, primary) && !strings.EqualFold(current, primary) {
+			irc.reclaimNick()
+		}
+	}
+}
+
+// identify identifies with NickServ, if a password is configured
+// and the client hasn't already authenticated via SASL.
+func (irc *IRC) identify() {
+	if irc.config.NickServPassword == "" || irc.caps.isAuthenticated() {
+		return
+	}
+	irc.queuePriority("PRIVMSG NickServ :IDENTIFY " + irc.config.NickServPassword)
+}
+
+// recoverNick asks NickServ to disconnect whoever is using the
+// primary nickname, if configured to, and then reclaims it.
+func (irc *IRC) recoverNick() {
+	password, primary := irc.config.NickServPassword, irc.primaryNick()
+	if password == "" {
+		return
+	}
+
+	switch strings.ToUpper(irc.config.NickServRecover) {
+	case "GHOST":
+		irc.queuePriority(fmt.Sprintf("PRIVMSG NickServ :GHOST %s %s", primary, password))
+		irc.reclaimNick()
+	case "REGAIN":
+		irc.queuePriority(fmt.Sprintf("PRIVMSG NickServ :REGAIN %s %s", primary, password))
+	}
+}
+
+// reclaimNick tries to switch back to the primary nickname.
+func (irc *IRC) reclaimNick() {
+	irc.queuePriority("NICK " + irc.primaryNick())
+}
+
+// primaryNick returns the nickname the client registered with.
+func (irc *IRC) primaryNick() string {
+	irc.mu.RLock()
+	defer irc.mu.RUnlock()
+
+	return irc.user
+}
+
+// handleNickReclaim runs in the background and periodically
+// tries to reclaim the primary nickname, while the client is
+// registered using a fallback one.
+func (irc *IRC) handleNickReclaim() {
+	defer irc.wg.Done()
+
+	interval := irc.config.NickReclaimInterval
+	if interval < 0 {
+		return
+	}
+	if interval == 0 {
+		interval = DefaultNickReclaimInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			irc.mu.RLock()
+			reclaim := irc.registered && !strings.EqualFold(irc.self.Nick, irc.user)
+			irc.mu.RUnlock()
+
+			if reclaim {
+				irc.reclaimNick()
+			}
+		case <-irc.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package irc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// register completes the registration of the given client,
+// accepting the given nickname.
+func register(irc *IRC, server *testServer, user string) chan error {
+	result := make(chan error, 1)
+	go func() { result <- irc.Join(user) }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK " + user)
+	server.expect("USER " + user + " 0.0.0.0 0.0.0.0 :" + user)
+
+	return result
+}
+
+func TestFallbackNicks(t *testing.T) {
+	irc, server := newTestIRC(t, Config{AltNicks: []string{"got2"}})
+	result := register(irc, server, "got")
+
+	server.send(":irc.example.com 433 * got :Nickname is already in use")
+	server.expect("NICK got2")
+
+	server.send(":irc.example.com 433 * got2 :Nickname is already in use")
+	server.expect("NICK got_")
+
+	server.send(":irc.example.com 432 * got_ :Erroneous nickname")
+	server.expect("NICK got__")
+
+	server.welcome("got__")
+	assert.Nil(t, <-result)
+	assert.Equal(t, "got__", irc.Nick())
+}
+
+func TestNickServIdentify(t *testing.T) {
+	irc, server := newTestIRC(t, Config{NickServPassword: "secret"})
+	result := register(irc, server, "got")
+
+	server.send(":irc.example.com 001 got :Welcome")
+	server.expect("PRIVMSG NickServ :IDENTIFY secret")
+	server.send(":irc.example.com 422 got :MOTD File is missing")
+	assert.Nil(t, <-result)
+}
+
+func TestNickServIdentifyAfterSASL(t *testing.T) {
+	for code, identify := range map[string]bool{"903": false, "904": true} {
+		irc, server := newTestIRC(t, Config{NickServPassword: "secret", SASLMechanism: "PLAIN"})
+		result := register(irc, server, "got")
+
+		server.send(":irc.example.com CAP * LS :sasl")
+		server.expect("CAP REQ :sasl")
+		server.send(":irc.example.com CAP * ACK :sasl")
+		server.expect("AUTHENTICATE PLAIN")
+		server.send(":irc.example.com " + code + " got :SASL authentication")
+		server.expect("CAP END")
+
+		server.send(":irc.example.com 001 got :Welcome")
+		if identify {
+			server.expect("PRIVMSG NickServ :IDENTIFY secret")
+		}
+		server.send(":irc.example.com 422 got :MOTD File is missing")
+		assert.Nil(t, <-result, code)
+
+		// Nothing else was sent before the PONG.
+		server.sync()
+	}
+}
+
+func TestNickServGhost(t *testing.T) {
+	irc, server := newTestIRC(t, Config{NickServPassword: "secret", NickServRecover: "ghost"})
+	result := register(irc, server, "got")
+
+	server.send(":irc.example.com 433 * got :Nickname is already in use")
+	server.expect("NICK got_")
+
+	server.send(":irc.example.com 001 got_ :Welcome")
+	server.expect("PRIVMSG NickServ :GHOST got secret")
+	server.expect("NICK got")
+	server.send(":irc.example.com 422 got_ :MOTD File is missing")
+	assert.Nil(t, <-result)
+
+	server.send(":got_!~got@example.com NICK :got")
+	server.expect("PRIVMSG NickServ :IDENTIFY secret")
+	server.sync()
+	assert.Equal(t, "got", irc.Nick())
+}
+
+func TestNickServRegain(t *testing.T) {
+	irc, server := newTestIRC(t, Config{NickServPassword: "secret", NickServRecover: "REGAIN"})
+	result := register(irc, server, "got")
+
+	server.send(":irc.example.com 433 * got :Nickname is already in use")
+	server.expect("NICK got_")
+
+	server.send(":irc.example.com 001 got_ :Welcome")
+	server.expect("PRIVMSG NickServ :REGAIN got secret")
+	server.send(":irc.example.com 422 got_ :MOTD File is missing")
+	assert.Nil(t, <-result)
+}
+
+func TestReclaimNickWhenFree(t *testing.T) {
+	irc, server := newTestIRC(t, Config{NickReclaimInterval: -1})
+	result := register(irc, server, "got")
+
+	server.send(":irc.example.com 433 * got :Nickname is already in use")
+	server.expect("NICK got_")
+	server.welcome("got_")
+	assert.Nil(t, <-result)
+
+	server.send(":got!~other@example.com QUIT :bye")
+	server.expect("NICK got")
+
+	server.send(":irc.example.com 433 got_ got :Nickname is already in use")
+	server.send(":got!~other@example.com NICK :notgot")
+	server.expect("NICK got")
+}
+
+func TestReclaimNickPeriodically(t *testing.T) {
+	irc, server := newTestIRC(t, Config{NickReclaimInterval: 20 * time.Millisecond})
+	result := register(irc, server, "got")
+
+	server.send(":irc.example.com 433 * got :Nickname is already in use")
+	server.expect("NICK got_")
+	server.welcome("got_")
+	assert.Nil(t, <-result)
+
+	server.expect("NICK got")
+}
+
+func TestFallbackNickExhausted(t *testing.T) {
+	irc := &IRC{user: "got", config: Config{AltNicks: []string{"a", "b"}}}
+
+	assert.Equal(t, "a", irc.fallbackNick(1))
+	assert.Equal(t, "b", irc.fallbackNick(2))
+	assert.Equal(t, "got_", irc.fallbackNick(3))
+	assert.Equal(t, "got_____", irc.fallbackNick(7))
+	assert.Equal(t, "", irc.fallbackNick(8))
+}
@@ -0,0 +1,321 @@
+package irc
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ErrProxyAuth is returned when a proxy rejects the credentials,
+// or requires credentials that were not given.
+var ErrProxyAuth = errors.New("irc: proxy authentication failed")
+
+// SOCKS5Dialer is a Dialer that connects through a SOCKS5 proxy
+// (RFC 1928), optionally authenticating with a username and
+// password (RFC 1929).
+type SOCKS5Dialer struct {
+	// The proxy address, in the host:port form.
+	Address string
+
+	// The credentials used to authenticate with the proxy.
+	// If User is empty, no authentication is offered.
+	User     string
+	Password string
+
+	// The Dialer used to connect to the proxy.
+	// If nil, connections are made over TCP.
+	Dialer Dialer
+}
+
+// SOCKS5 protocol values.
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthRejected = 0xff
+	socks5Connect      = 0x01
+	socks5IPv4         = 0x01
+	socks5Domain       = 0x03
+	socks5IPv6         = 0x04
+)
+
+// The SOCKS5 reply codes, indexed by code.
+var socks5Replies = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// Dial connects to the proxy, and asks it to connect
+// to the given address.
+func (d *SOCKS5Dialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := forward(d.Dialer).Dial(network, d.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := handshake(conn, func() error { return d.connect(conn, address) }); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// connect authenticates with the proxy, if needed, and
+// asks it to connect to the given address.
+func (d *SOCKS5Dialer) connect(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return fmt.Errorf("irc: invalid port %q", portStr)
+	}
+
+	methods := []byte{socks5AuthNone}
+	if d.User != "" {
+		methods = append(methods, socks5AuthPassword)
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("irc: unexpected SOCKS version %d", reply[0])
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if err := d.authenticate(conn); err != nil {
+			return err
+		}
+	case socks5AuthRejected:
+		return ErrProxyAuth
+	default:
+		return fmt.Errorf("irc: unsupported SOCKS authentication method %d", reply[1])
+	}
+
+	req := []byte{socks5Version, socks5Connect, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return fmt.Errorf("irc: host name too long: %s", host)
+		}
+		req = append(req, socks5Domain, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, socks5IPv4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, socks5IPv6)
+		req = append(req, ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	return readSOCKS5Reply(conn)
+}
+
+// authenticate sends the username and password to the proxy.
+func (d *SOCKS5Dialer) authenticate(conn net.Conn) error {
+	if len(d.User) > 255 || len(d.Password) > 255 {
+		return errors.New("irc: SOCKS credentials too long")
+	}
+
+	req := []byte{0x01, byte(len(d.User))}
+	req = append(req, d.User...)
+	req = append(req, byte(len(d.Password)))
+	req = append(req, d.Password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[1] != 0 {
+		return ErrProxyAuth
+	}
+	return nil
+}
+
+// readSOCKS5Reply reads the reply to a connect request, which
+// is followed by the address the proxy is bound to.
+func readSOCKS5Reply(conn net.Conn) error {
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if code := reply[1]; code != 0 {
+		if int(code) < len(socks5Replies) {
+			return fmt.Errorf("irc: SOCKS proxy: %s", socks5Replies[code])
+		}
+		return fmt.Errorf("irc: SOCKS proxy: unknown error %d", code)
+	}
+
+	var size int
+	switch reply[3] {
+	case socks5IPv4:
+		size = net.IPv4len
+	case socks5IPv6:
+		size = net.IPv6len
+	case socks5Domain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		size = int(length[0])
+	default:
+		return fmt.Errorf("irc: unknown SOCKS address type %d", reply[3])
+	}
+
+	// The bound address and port are of no use.
+	_, err := io.ReadFull(conn, make([]byte, size+2))
+	return err
+}
+
+// HTTPProxyDialer is a Dialer that connects through an HTTP proxy,
+// using the CONNECT method, optionally authenticating with a
+// username and password (basic authentication).
+type HTTPProxyDialer struct {
+	// The proxy address, in the host:port form.
+	Address string
+
+	// The credentials used to authenticate with the proxy.
+	// If User is empty, no credentials are sent.
+	User     string
+	Password string
+
+	// The Dialer used to connect to the proxy.
+	// If nil, connections are made over TCP.
+	Dialer Dialer
+}
+
+// Dial connects to the proxy, and asks it to connect
+// to the given address.
+func (d *HTTPProxyDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := forward(d.Dialer).Dial(network, d.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	var proxied net.Conn
+	err = handshake(conn, func() (err error) {
+		proxied, err = d.connect(conn, address)
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return proxied, nil
+}
+
+// connect asks the proxy to connect to the given address.
+func (d *HTTPProxyDialer) connect(conn net.Conn, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if d.User != "" {
+		credentials := base64.StdEncoding.EncodeToString([]byte(d.User + ":" + d.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(buf, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusProxyAuthRequired:
+		return nil, ErrProxyAuth
+	default:
+		return nil, fmt.Errorf("irc: HTTP proxy: %s", resp.Status)
+	}
+
+	// The server may have spoken already (e.g. NOTICE AUTH),
+	// in which case its messages are buffered.
+	if buf.Buffered() > 0 {
+		return &bufferedConn{conn, buf}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first bytes were read
+// into a buffer, which is drained before reading any further.
+type bufferedConn struct {
+	net.Conn
+
+	// The buffer the connection was read into.
+	buf *bufio.Reader
+}
+
+// Read reads from the buffer, and then from the connection.
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.buf.Read(b)
+}
+
+// ProxyDialer returns the Dialer for the proxy with the given URL,
+// in the socks5://[user:password@]host:port or the
+// http://[user:password@]host:port form, which connects to the
+// proxy using the given Dialer (TCP if nil).
+func ProxyDialer(proxyURL string, d Dialer) (Dialer, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("irc: proxy host missing in %q", proxyURL)
+	}
+
+	user := u.User.Username()
+	password, _ := u.User.Password()
+
+	switch u.Scheme {
+	case "socks5", "socks5h":
+		return &SOCKS5Dialer{proxyAddress(u, "1080"), user, password, d}, nil
+	case "http":
+		return &HTTPProxyDialer{proxyAddress(u, "8080"), user, password, d}, nil
+	default:
+		return nil, fmt.Errorf("irc: unsupported proxy scheme %q", u.Scheme)
+	}
+}
+
+// proxyAddress returns the address of the proxy with the given
+// URL, in the host:port form, using the given port if it has none.
+func proxyAddress(u *url.URL, port string) string {
+	if p := u.Port(); p != "" {
+		port = p
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
@@ -0,0 +1,257 @@
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Default reconnection settings.
+const (
+	DefaultReconnectDelay    = 1 * time.Second
+	DefaultReconnectMaxDelay = 5 * time.Minute
+)
+
+// ErrClosed is returned when the client is closed while
+// connecting, registering or waiting to reconnect.
+var ErrClosed = errors.New("irc: client closed")
+
+// ErrConnected is returned by Reconnect when the client
+// is still connected.
+var ErrConnected = errors.New("irc: still connected")
+
+// errStaleSession is returned when the client reconnects
+// while registering the previous connection.
+var errStaleSession = errors.New("irc: connection replaced while registering")
+
+// session tracks one of the connections to the server,
+// and the registration over it.
+type session struct {
+	// Closed when the connection is replaced by a new one,
+	// so that registering over it gives up.
+	replaced chan struct{}
+
+	// Closed when the registration over the connection
+	// finishes, once err holds its result.
+	registered chan struct{}
+	err        error
+}
+
+// newSession creates a session for a new connection.
+func newSession() *session {
+	return &session{
+		replaced:   make(chan struct{}),
+		registered: make(chan struct{}),
+	}
+}
+
+// finish records the result of the registration.
+func (s *session) finish(err error) {
+	s.err = err
+	close(s.registered)
+}
+
+// wait blocks until the registration over the connection finishes,
+// returning its result, errStaleSession if the connection is replaced
+// first, or ErrClosed if the given channel is closed first.
+func (s *session) wait(closed chan struct{}) error {
+	select {
+	case <-s.registered:
+		return s.err
+	case <-s.replaced:
+		return errStaleSession
+	case <-closed:
+		return ErrClosed
+	}
+}
+
+// callbacks holds the functions to be called when the
+// client connects to and disconnects from the server.
+type callbacks struct {
+	sync.RWMutex
+
+	onConnect    []func()
+	onDisconnect []func(error)
+}
+
+// connected calls the OnConnect callbacks.
+func (c *callbacks) connected() {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, f := range c.onConnect {
+		f()
+	}
+}
+
+// disconnected calls the OnDisconnect callbacks.
+func (c *callbacks) disconnected(err error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	for _, f := range c.onDisconnect {
+		f(err)
+	}
+}
+
+// OnConnect registers a callback to be called every time the
+// client finishes registering with the server and joining the
+// channels, including after reconnecting.
+func (irc *IRC) OnConnect(callback func()) {
+	irc.callbacks.Lock()
+	defer irc.callbacks.Unlock()
+
+	irc.callbacks.onConnect = append(irc.callbacks.onConnect, callback)
+}
+
+// OnDisconnect registers a callback to be called every time
+// the connection to the server is lost, with the error that
+// caused it. The callback is called before reconnecting.
+func (irc *IRC) OnDisconnect(callback func(error)) {
+	irc.callbacks.Lock()
+	defer irc.callbacks.Unlock()
+
+	irc.callbacks.onDisconnect = append(irc.callbacks.onDisconnect, callback)
+}
+
+// Errors returns the channel where the client reports the
+// failures it can't recover from on its own: reconnecting giving
+// up after the configured number of attempts, the server refusing
+// the registration after reconnecting, or the connection being
+// lost while automatic reconnection is disabled. After
+// a failure is reported, the client stays disconnected until
+// Reconnect is called; whether to do so or to exit is up to
+// the caller.
+func (irc *IRC) Errors() <-chan error {
+	return irc.errors
+}
+
+// Reconnect connects to the server again after a failure has been
+// reported on the errors channel, registering and rejoining the
+// channels in the background. It returns an error if the server
+// can't be reached, in which case it is reported on the errors
+// channel as well, or if the client is still connected.
+func (irc *IRC) Reconnect() error {
+	irc.mu.RLock()
+	failed := irc.failed
+	irc.mu.RUnlock()
+
+	if !failed {
+		return ErrConnected
+	}
+
+	result := make(chan error, 1)
+	select {
+	case irc.resume <- result:
+		return <-result
+	case <-irc.done:
+		return ErrClosed
+	}
+}
+
+// reconnect replaces the current connection with a new one
+// and registers again in the background. It returns an error
+// if the server can't be reached within the configured number
+// of attempts, or ErrClosed if the client is closed meanwhile.
+func (irc *IRC) reconnect() error {
+	conn, err := connect(irc.config, irc.done)
+	if err != nil {
+		return err
+	}
+
+	irc.mu.Lock()
+	irc.conn = conn
+	previous := irc.session
+	irc.session = newSession()
+	session := irc.session
+	irc.mu.Unlock()
+	close(previous.replaced)
+
+	irc.wg.Add(1)
+	go func() {
+		defer irc.wg.Done()
+		if err := irc.register(session); err != nil && err != errStaleSession && err != ErrClosed {
+			log.Printf("[IRC] Registration failed after reconnecting: %v\n", err)
+			irc.dropRegistration(session, err)
+		}
+	}()
+
+	return nil
+}
+
+// dropRegistration closes the connection of the given session,
+// whose registration failed with the given error, so that the
+// error is reported on the errors channel instead of the client
+// staying connected but unregistered.
+func (irc *IRC) dropRegistration(session *session, err error) {
+	irc.mu.Lock()
+	if irc.session != session {
+		irc.mu.Unlock()
+		return
+	}
+	irc.registerErr = err
+	conn := irc.conn
+	irc.mu.Unlock()
+
+	conn.Close()
+}
+
+// connect dials to the configured server and returns the
+// connection. Failed attempts are retried with an exponential
+// backoff, until the configured maximum number of attempts is
+// reached or the given channel is closed. Invalid settings
+// (e.g. a missing TLS certificate) are not retried.
+func connect(config Config, done chan struct{}) (net.Conn, error) {
+	d, err := dialer(config)
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 1; ; attempt++ {
+		conn, err := d.Dial("tcp", config.address())
+		if err == nil {
+			log.Printf("[IRC] Connected to %s (%s).\n", config.Server, conn.RemoteAddr())
+			return conn, nil
+		}
+
+		if max := config.ReconnectMaxAttempts; max > 0 && attempt >= max {
+			return nil, fmt.Errorf("irc: giving up connecting to %s after %d attempts: %v", config.address(), attempt, err)
+		}
+
+		delay := backoff(config, attempt)
+		log.Printf("[IRC] Error [%s] while connecting, retrying in %s...\n", err, delay)
+
+		select {
+		case <-time.After(delay):
+		case <-done:
+			return nil, ErrClosed
+		}
+	}
+}
+
+// backoff returns how long to wait before the next connection
+// attempt. The delay doubles after each attempt, up to the
+// configured maximum, and is randomised by up to 50% in order
+// to avoid reconnecting in lockstep with other clients.
+func backoff(config Config, attempt int) time.Duration {
+	delay, max := config.ReconnectDelay, config.ReconnectMaxDelay
+	if delay <= 0 {
+		delay = DefaultReconnectDelay
+	}
+	if max <= 0 {
+		max = DefaultReconnectMaxDelay
+	}
+
+	for i := 1; i < attempt && delay < max; i++ {
+		delay *= 2
+	}
+	if delay > max {
+		delay = max
+	}
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
@@ -0,0 +1,220 @@
+package irc
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestListener starts a local TCP listener and returns it,
+// along with a configuration pointing to it.
+func newTestListener(t *testing.T) (net.Listener, Config) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	return listener, Config{
+		Server:         "127.0.0.1",
+		Port:           p,
+		ReconnectDelay: 10 * time.Millisecond,
+	}
+}
+
+// accept waits for the next connection to the given listener.
+func accept(t *testing.T, listener net.Listener) *testServer {
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testServer{t, conn, bufio.NewReader(conn)}
+}
+
+func TestReconnectRegistersAndRejoins(t *testing.T) {
+	listener, config := newTestListener(t)
+	config.Channels = []ChannelConfig{{Name: "#got"}}
+
+	irc, err := NewIRC(config)
+	assert.Nil(t, err)
+
+	connected := make(chan bool, 2)
+	disconnected := make(chan error, 1)
+	irc.OnConnect(func() { connected <- true })
+	irc.OnDisconnect(func(err error) { disconnected <- err })
+
+	server := accept(t, listener)
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+	server.welcome("gotgotgot")
+	server.expect("JOIN #got")
+	assert.Nil(t, <-result)
+	assert.True(t, <-connected)
+
+	go irc.JoinChannel("#beer", "")
+	server.expect("JOIN #beer")
+
+	server.conn.Close()
+	assert.NotNil(t, <-disconnected)
+
+	server = accept(t, listener)
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+	server.welcome("gotgotgot")
+	server.expect("JOIN #beer")
+	server.expect("JOIN #got")
+	assert.True(t, <-connected)
+}
+
+func TestConnectGivesUpAfterMaxAttempts(t *testing.T) {
+	listener, config := newTestListener(t)
+	config.ReconnectMaxAttempts = 3
+	listener.Close()
+
+	start := time.Now()
+	_, err := connect(config, nil)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "after 3 attempts")
+	assert.True(t, time.Since(start) >= 15*time.Millisecond)
+}
+
+func TestNewIRCFailsToConnect(t *testing.T) {
+	listener, config := newTestListener(t)
+	config.ReconnectMaxAttempts = 1
+	listener.Close()
+
+	irc, err := NewIRC(config)
+
+	assert.Nil(t, irc)
+	assert.NotNil(t, err)
+}
+
+func TestReconnectGivingUpIsReported(t *testing.T) {
+	listener, config := newTestListener(t)
+	config.ReconnectMaxAttempts = 2
+
+	irc, err := NewIRC(config)
+	assert.Nil(t, err)
+
+	server := accept(t, listener)
+	listener.Close()
+	server.conn.Close()
+
+	err = <-irc.Errors()
+	assert.Contains(t, err.Error(), "after 2 attempts")
+}
+
+func TestReconnectWhenDisabled(t *testing.T) {
+	listener, config := newTestListener(t)
+	config.DisableReconnect = true
+
+	irc, err := NewIRC(config)
+	assert.Nil(t, err)
+	assert.Equal(t, ErrConnected, irc.Reconnect())
+
+	server := accept(t, listener)
+	result := register(irc, server, "got")
+	server.welcome("got")
+	assert.Nil(t, <-result)
+
+	server.conn.Close()
+	assert.NotNil(t, <-irc.Errors())
+
+	result = make(chan error)
+	go func() { result <- irc.Reconnect() }()
+
+	server = accept(t, listener)
+	assert.Nil(t, <-result)
+	server.expect("CAP LS 302")
+	server.expect("NICK got")
+	server.expect("USER got 0.0.0.0 0.0.0.0 :got")
+
+	assert.Equal(t, ErrConnected, irc.Reconnect())
+}
+
+func TestRegistrationFailureAfterReconnectIsReported(t *testing.T) {
+	listener, config := newTestListener(t)
+
+	irc, err := NewIRC(config)
+	assert.Nil(t, err)
+
+	server := accept(t, listener)
+	result := register(irc, server, "got")
+	server.welcome("got")
+	assert.Nil(t, <-result)
+
+	server.conn.Close()
+	server = accept(t, listener)
+	server.expect("CAP LS 302")
+	server.expect("NICK got")
+	server.expect("USER got 0.0.0.0 0.0.0.0 :got")
+	server.send(
+		":irc.example.com 421 got CAP :Unknown command",
+		":irc.example.com 464 got :Password incorrect",
+	)
+
+	select {
+	case err := <-irc.Errors():
+		assert.NotNil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("registration failure not reported")
+	}
+	assert.Nil(t, irc.Reconnect())
+}
+
+func TestConnectStopsWhenClosed(t *testing.T) {
+	listener, config := newTestListener(t)
+	config.ReconnectDelay = time.Hour
+	listener.Close()
+
+	done := make(chan struct{})
+	close(done)
+
+	_, err := connect(config, done)
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestBackoff(t *testing.T) {
+	config := Config{ReconnectDelay: time.Second, ReconnectMaxDelay: 10 * time.Second}
+
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		delay := backoff(config, attempt+1)
+
+		assert.True(t, delay >= expected/2, "attempt %d: %s", attempt+1, delay)
+		assert.True(t, delay <= expected, "attempt %d: %s", attempt+1, delay)
+	}
+}
+
+func TestBackoffDefaults(t *testing.T) {
+	assert.True(t, backoff(Config{}, 1) <= DefaultReconnectDelay)
+	assert.True(t, backoff(Config{}, 100) <= DefaultReconnectMaxDelay)
+	assert.True(t, backoff(Config{}, 100) >= DefaultReconnectMaxDelay/2)
+}
+
+func TestCallbacks(t *testing.T) {
+	irc := &IRC{callbacks: &callbacks{}}
+
+	var calls []string
+	irc.OnConnect(func() { calls = append(calls, "connect") })
+	irc.OnDisconnect(func(err error) { calls = append(calls, err.Error()) })
+
+	irc.callbacks.connected()
+	irc.callbacks.disconnected(errors.New("EOF"))
+
+	assert.Equal(t, []string{"connect", "EOF"}, calls)
+}
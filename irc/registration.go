@@ -0,0 +1,119 @@
+package irc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Numeric replies sent by the server during the registration.
+const (
+	rplEndOfMOTD        = "376"
+	errNoMOTD           = "422"
+	errPasswdMismatch   = "464"
+	errYoureBannedCreep = "465"
+)
+
+// How long to wait for the registration to complete.
+const registrationTimeout = time.Minute
+
+// ErrRegistrationTimeout is returned when the server does not
+// complete the registration in time.
+var ErrRegistrationTimeout = errors.New("irc: timed out waiting for registration")
+
+// ErrNoNickAvailable is returned when none of the nicknames
+// the client tried while registering are available.
+var ErrNoNickAvailable = errors.New("irc: no nickname available")
+
+// RegistrationError is returned when the server refuses
+// the registration.
+type RegistrationError struct {
+	// The numeric reply or command sent by the server.
+	Code string
+
+	// The reason given by the server.
+	Reason string
+}
+
+func (e RegistrationError) Error() string {
+	return fmt.Sprintf("irc: registration refused (%s): %s", e.Code, e.Reason)
+}
+
+// registration keeps track of the connection registration,
+// which completes once the server has welcomed the client
+// (RPL_WELCOME) and sent the message of the day (or reported
+// it is missing).
+type registration struct {
+	sync.Mutex
+
+	// Whether the server has welcomed the client.
+	welcomed bool
+
+	// The channel where the registration result is sent,
+	// or nil if the registration is not in progress.
+	done chan error
+}
+
+// start begins a new registration, discarding the state of any
+// previous one. It returns the channel where the result is sent.
+func (r *registration) start() chan error {
+	r.Lock()
+	defer r.Unlock()
+
+	r.welcomed = false
+	r.done = make(chan error, 1)
+	return r.done
+}
+
+// wait blocks until the registration that sends its result to
+// the given channel completes, returning its result, until the
+// closed channel is closed, or until the replaced channel is
+// closed, i.e. the client reconnects in the meantime.
+func (r *registration) wait(done chan error, closed, replaced chan struct{}) error {
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(registrationTimeout):
+		return ErrRegistrationTimeout
+	case <-closed:
+		return ErrClosed
+	case <-replaced:
+		return errStaleSession
+	}
+}
+
+// handle reacts to the messages sent by the server during
+// the registration.
+func (r *registration) handle(msg Message) {
+	switch msg.Command {
+	case rplWelcome:
+		r.Lock()
+		r.welcomed = true
+		r.Unlock()
+	case rplEndOfMOTD, errNoMOTD:
+		r.Lock()
+		welcomed := r.welcomed
+		r.Unlock()
+
+		if welcomed {
+			r.finish(nil)
+		}
+	case errPasswdMismatch, errYoureBannedCreep:
+		r.finish(RegistrationError{msg.Command, msg.Arg(len(msg.Args()) - 1)})
+	case "ERROR":
+		r.finish(RegistrationError{msg.Command, msg.Arg(0)})
+	}
+}
+
+// finish ends the registration in progress, if any,
+// with the given result.
+func (r *registration) finish(err error) {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.done != nil {
+		r.done <- err
+		r.done = nil
+	}
+}
@@ -0,0 +1,159 @@
+package irc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinWaitsForEndOfMOTD(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#got"}}})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com 421 gotgotgot CAP :Unknown command")
+	server.send(":irc.example.com 001 gotgotgot :Welcome")
+	server.sync()
+
+	select {
+	case err := <-result:
+		t.Fatalf("joined before the end of the MOTD: %v", err)
+	default:
+	}
+
+	server.send(
+		":irc.example.com 375 gotgotgot :- Message of the day -",
+		":irc.example.com 372 gotgotgot :- Don't panic",
+		":irc.example.com 376 gotgotgot :End of MOTD command",
+	)
+	server.expect("JOIN #got")
+	assert.Nil(t, <-result)
+}
+
+func TestJoinIgnoresMOTDBeforeWelcome(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#got"}}})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(
+		":irc.example.com 421 gotgotgot CAP :Unknown command",
+		":irc.example.com 422 * :MOTD File is missing",
+	)
+	server.welcome("gotgotgot")
+	server.expect("JOIN #got")
+	assert.Nil(t, <-result)
+}
+
+func TestJoinSendsServerPassword(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Password: "secret"})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("PASS secret")
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com CAP * LS :multi-prefix")
+	server.expect("CAP END")
+	server.welcome("gotgotgot")
+	assert.Nil(t, <-result)
+}
+
+func TestJoinWithWrongPassword(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#got"}}, Password: "wrong"})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("PASS wrong")
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(
+		":irc.example.com 421 gotgotgot CAP :Unknown command",
+		":irc.example.com 464 gotgotgot :Password incorrect",
+	)
+	assert.Equal(t, RegistrationError{"464", "Password incorrect"}, <-result)
+}
+
+func TestJoinWhenBanned(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#got"}}})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(
+		":irc.example.com 421 gotgotgot CAP :Unknown command",
+		":irc.example.com 465 gotgotgot :You are banned from this server",
+		"ERROR :Closing Link: gotgotgot (K-lined)",
+	)
+	assert.Equal(t, RegistrationError{"465", "You are banned from this server"}, <-result)
+}
+
+func TestJoinWhenServerClosesLink(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(
+		":irc.example.com 421 gotgotgot CAP :Unknown command",
+		"ERROR :Closing Link: gotgotgot (Throttled)",
+	)
+	assert.Equal(t, RegistrationError{"ERROR", "Closing Link: gotgotgot (Throttled)"}, <-result)
+}
+
+func TestJoinWithoutAvailableNick(t *testing.T) {
+	irc, server := newTestIRC(t, Config{AltNicks: []string{"got2"}})
+	result := register(irc, server, "got")
+
+	server.send(":irc.example.com 421 got CAP :Unknown command")
+	nick := "got"
+	for _, next := range []string{"got2", "got_", "got__", "got___", "got____", "got_____"} {
+		server.send(":irc.example.com 433 * " + nick + " :Nickname is already in use")
+		server.expect("NICK " + next)
+		nick = next
+	}
+
+	server.send(":irc.example.com 433 * " + nick + " :Nickname is already in use")
+	assert.Equal(t, ErrNoNickAvailable, <-result)
+}
+
+func TestJoinWithNumericWithoutParams(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Password: "wrong"})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("PASS wrong")
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(
+		":irc.example.com 421 gotgotgot CAP :Unknown command",
+		":irc.example.com 464",
+	)
+	assert.Equal(t, RegistrationError{"464", ""}, <-result)
+}
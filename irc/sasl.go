@@ -0,0 +1,112 @@
+package irc
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Numeric replies sent by the server during
+// the SASL authentication.
+const (
+	rplLoggedIn    = "900"
+	errNickLocked  = "902"
+	rplSASLSuccess = "903"
+	errSASLFail    = "904"
+	errSASLTooLong = "905"
+	errSASLAborted = "906"
+	errSASLAlready = "907"
+	rplSASLMechs   = "908"
+)
+
+// The maximum length of each AUTHENTICATE payload.
+const saslChunkSize = 400
+
+// ErrSASLUnsupported is returned when the server does not
+// support SASL authentication.
+var ErrSASLUnsupported = errors.New("irc: server does not support SASL")
+
+// SASLError is returned when the server rejects
+// the SASL authentication.
+type SASLError struct {
+	// The numeric reply sent by the server.
+	Code string
+
+	// The reason given by the server.
+	Reason string
+}
+
+func (e SASLError) Error() string {
+	return fmt.Sprintf("irc: SASL authentication failed (%s): %s", e.Code, e.Reason)
+}
+
+// sasl holds the state of the SASL authentication.
+type sasl struct {
+	// The mechanism used to authenticate.
+	mechanism string
+
+	// The credentials used by the PLAIN mechanism.
+	user   string
+	passwd string
+}
+
+// newSASL creates the SASL state from the given configuration.
+func newSASL(config Config) *sasl {
+	return &sasl{
+		mechanism: strings.ToUpper(config.SASLMechanism),
+		user:      config.SASLUser,
+		passwd:    config.SASLPassword,
+	}
+}
+
+// start begins the authentication with the
+// configured mechanism.
+func (s *sasl) start(send func(string)) {
+	send("AUTHENTICATE " + s.mechanism)
+}
+
+// handle reacts to the messages exchanged during the
+// authentication, sending the responses with the given function.
+// It reports whether the authentication has finished, and
+// its result.
+func (s *sasl) handle(msg Message, send func(string)) (bool, error) {
+	switch msg.Command {
+	case "AUTHENTICATE":
+		if msg.Arg(0) == "+" {
+			for _, line := range s.response() {
+				send("AUTHENTICATE " + line)
+			}
+		}
+	case rplSASLSuccess, errSASLAlready:
+		return true, nil
+	case errNickLocked, errSASLFail, errSASLTooLong, errSASLAborted:
+		return true, SASLError{msg.Command, msg.Arg(len(msg.Args()) - 1)}
+	}
+	return false, nil
+}
+
+// response returns the base64 encoded response for the
+// configured mechanism, split into chunks as required by
+// the protocol.
+func (s *sasl) response() []string {
+	var payload []byte
+	if s.mechanism == "PLAIN" {
+		payload = []byte(s.user + "\x00" + s.user + "\x00" + s.passwd)
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(payload)
+	if encoded == "" {
+		return []string{"+"}
+	}
+
+	var lines []string
+	for len(encoded) >= saslChunkSize {
+		lines = append(lines, encoded[:saslChunkSize])
+		encoded = encoded[saslChunkSize:]
+	}
+	if encoded == "" {
+		encoded = "+"
+	}
+	return append(lines, encoded)
+}
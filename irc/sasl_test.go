@@ -0,0 +1,201 @@
+package irc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJoinWithSASLPlain(t *testing.T) {
+	irc, server := newTestIRC(t, Config{
+		Channels:      []ChannelConfig{{Name: "#got"}},
+		SASLMechanism: "PLAIN",
+		SASLUser:      "marvin",
+		SASLPassword:  "paranoid",
+	})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com CAP * LS :multi-prefix sasl=PLAIN,EXTERNAL")
+	server.expect("CAP REQ :sasl")
+
+	server.send(":irc.example.com CAP * ACK :sasl")
+	server.expect("AUTHENTICATE PLAIN")
+
+	server.send("AUTHENTICATE +")
+	server.expect("AUTHENTICATE bWFydmluAG1hcnZpbgBwYXJhbm9pZA==")
+
+	server.send(":irc.example.com 903 gotgotgot :SASL authentication successful")
+	server.expect("CAP END")
+	server.welcome("gotgotgot")
+	server.expect("JOIN #got")
+
+	assert.Nil(t, <-result)
+}
+
+func TestJoinWithSASLExternal(t *testing.T) {
+	irc, server := newTestIRC(t, Config{
+		Channels:      []ChannelConfig{{Name: "#got", Key: "secret"}},
+		SASLMechanism: "external",
+	})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com CAP * LS :multi-prefix sasl=PLAIN,EXTERNAL")
+	server.expect("CAP REQ :sasl")
+
+	server.send(":irc.example.com CAP * ACK :sasl")
+	server.expect("AUTHENTICATE EXTERNAL")
+
+	server.send("AUTHENTICATE +")
+	server.expect("AUTHENTICATE +")
+
+	server.send(":irc.example.com 903 gotgotgot :SASL authentication successful")
+	server.expect("CAP END")
+	server.welcome("gotgotgot")
+	server.expect("JOIN #got secret")
+
+	assert.Nil(t, <-result)
+}
+
+func TestJoinWithSASLFailureProceeds(t *testing.T) {
+	irc, server := newTestIRC(t, Config{Channels: []ChannelConfig{{Name: "#got"}}, SASLMechanism: "PLAIN"})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com CAP * LS :multi-prefix sasl=PLAIN,EXTERNAL")
+	server.expect("CAP REQ :sasl")
+
+	server.send(":irc.example.com CAP * ACK :sasl")
+	server.expect("AUTHENTICATE PLAIN")
+
+	server.send("AUTHENTICATE +")
+	server.expect("AUTHENTICATE AAA=")
+
+	server.send(":irc.example.com 904 gotgotgot :SASL authentication failed")
+	server.expect("CAP END")
+	server.welcome("gotgotgot")
+	server.expect("JOIN #got")
+
+	assert.Nil(t, <-result)
+}
+
+func TestJoinWithSASLFailureAborts(t *testing.T) {
+	irc, server := newTestIRC(t, Config{
+		Channels:           []ChannelConfig{{Name: "#got"}},
+		SASLMechanism:      "PLAIN",
+		SASLAbortOnFailure: true,
+	})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com CAP * LS :multi-prefix sasl=PLAIN,EXTERNAL")
+	server.expect("CAP REQ :sasl")
+
+	server.send(":irc.example.com CAP * ACK :sasl")
+	server.expect("AUTHENTICATE PLAIN")
+
+	server.send(":irc.example.com 905 gotgotgot :SASL message too long")
+	server.expect("CAP END")
+
+	assert.Equal(t, SASLError{"905", "SASL message too long"}, <-result)
+}
+
+func TestJoinWithSASLFailureWithoutParams(t *testing.T) {
+	irc, server := newTestIRC(t, Config{
+		Channels:           []ChannelConfig{{Name: "#got"}},
+		SASLMechanism:      "PLAIN",
+		SASLAbortOnFailure: true,
+	})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com CAP * LS :multi-prefix sasl=PLAIN,EXTERNAL")
+	server.expect("CAP REQ :sasl")
+
+	server.send(":irc.example.com CAP * ACK :sasl")
+	server.expect("AUTHENTICATE PLAIN")
+
+	server.send(":irc.example.com 904")
+	server.expect("CAP END")
+
+	assert.Equal(t, SASLError{"904", ""}, <-result)
+}
+
+func TestJoinWithSASLUnsupported(t *testing.T) {
+	irc, server := newTestIRC(t, Config{
+		Channels:           []ChannelConfig{{Name: "#got"}},
+		SASLMechanism:      "PLAIN",
+		SASLAbortOnFailure: true,
+	})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com CAP * LS :multi-prefix")
+	server.expect("CAP END")
+
+	assert.Equal(t, ErrSASLUnsupported, <-result)
+}
+
+func TestJoinWithSASLRejected(t *testing.T) {
+	irc, server := newTestIRC(t, Config{
+		Channels:           []ChannelConfig{{Name: "#got"}},
+		SASLMechanism:      "PLAIN",
+		SASLAbortOnFailure: true,
+	})
+
+	result := make(chan error)
+	go func() { result <- irc.Join("gotgotgot") }()
+
+	server.expect("CAP LS 302")
+	server.expect("NICK gotgotgot")
+	server.expect("USER gotgotgot 0.0.0.0 0.0.0.0 :gotgotgot")
+
+	server.send(":irc.example.com CAP * LS :sasl")
+	server.expect("CAP REQ :sasl")
+
+	server.send(":irc.example.com CAP * NAK :sasl")
+	server.expect("CAP END")
+
+	assert.Equal(t, ErrSASLUnsupported, <-result)
+}
+
+func TestSASLResponseChunks(t *testing.T) {
+	s := &sasl{mechanism: "PLAIN", user: "u", passwd: string(make([]byte, 296))}
+
+	lines := s.response()
+
+	assert.Len(t, lines, 2)
+	assert.Len(t, lines[0], 400)
+	assert.Equal(t, "+", lines[1])
+}
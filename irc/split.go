@@ -0,0 +1,226 @@
+package irc
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/caiofilipini/got/irc/format"
+)
+
+// The maximum length of a message, including the trailing "\r\n",
+// as defined in RFC 2812 (section 2.3).
+const maxMessageLength = 512
+
+// The maximum length of a hostname, used to estimate the length
+// of the client prefix until it is known.
+const maxHostLength = 63
+
+// The minimum number of bytes available for the text of each
+// line, regardless of how long the prefix and target are.
+const minTextLength = 32
+
+// The suffix added to the last line when a message is truncated
+// because it exceeds the maximum number of lines.
+const truncatedSuffix = "…"
+
+// sendText sends the given text to the target using the given
+// command (e.g. PRIVMSG or NOTICE). The text is split into as
+// many messages as needed to fit the protocol length limit, and
+// embedded line breaks always start a new message.
+func (irc *IRC) sendText(command, target, text string) {
+	for _, line := range irc.splitFor(command, target, irc.colorsFor(target, text), 0) {
+		irc.queue(command + " " + target + " :" + line)
+	}
+}
+
+// colorsFor returns the given text as it should be sent to the
+// target, i.e. without colors if the target is a channel that
+// doesn't allow them.
+func (irc *IRC) colorsFor(target, text string) string {
+	if irc.channels.noColors(target) {
+		return format.StripColors(text)
+	}
+	return text
+}
+
+// splitFor splits the given text into lines that fit in messages
+// sent to the target using the given command, leaving room for
+// the given number of bytes added to each line (e.g. by CTCP).
+func (irc *IRC) splitFor(command, target, text string, overhead int) []string {
+	max := maxMessageLength - len("\r\n") - len(irc.relayPrefix(command, target)) - overhead
+	if max < minTextLength {
+		max = minTextLength
+	}
+
+	return splitText(text, max, irc.config.MaxLines)
+}
+
+// relayPrefix returns the part of the message preceding the text,
+// as relayed by the server to other clients, including the client
+// prefix (e.g. ":nick!user@host PRIVMSG #channel :").
+func (irc *IRC) relayPrefix(command, target string) string {
+	irc.mu.RLock()
+	self, user := irc.self, irc.user
+	irc.mu.RUnlock()
+
+	if self.Host == "" {
+		if self.User == "" {
+			self.User = "~" + user
+		}
+		self.Host = strings.Repeat("x", maxHostLength)
+	}
+
+	return ":" + self.String() + " " + command + " " + target + " :"
+}
+
+// trackSelf keeps track of the client prefix, as seen by the
+// server, in order to calculate how long relayed messages are.
+func (irc *IRC) trackSelf(msg Message) {
+	irc.mu.Lock()
+	defer irc.mu.Unlock()
+
+	switch msg.Command {
+	case rplWelcome:
+		irc.self = Prefix{Nick: msg.Arg(0)}
+
+		words := strings.Fields(msg.Trailing)
+		if len(words) > 0 {
+			if p := ParsePrefix(words[len(words)-1]); p.Nick == irc.self.Nick && p.Host != "" {
+				irc.self = p
+			}
+		}
+	case "JOIN":
+		if strings.EqualFold(msg.Prefix.Nick, irc.self.Nick) && msg.Prefix.Host != "" {
+			irc.self = msg.Prefix
+		}
+	case "NICK":
+		if strings.EqualFold(msg.Prefix.Nick, irc.self.Nick) {
+			irc.self.Nick = msg.Arg(0)
+		}
+	}
+}
+
+// splitText splits the given text into lines of at most max bytes.
+// Embedded line breaks start new lines, and lines are preferably
+// split at word boundaries, never in the middle of a UTF-8 character
+// or a formatting code. Formatting active at the end of a line is
+// restored at the beginning of the next one. If maxLines is greater
+// than zero, the text is truncated to that many lines.
+func splitText(text string, max, maxLines int) []string {
+	var lines []string
+
+	for _, paragraph := range strings.FieldsFunc(text, func(r rune) bool { return r == '\r' || r == '\n' }) {
+		for len(paragraph) > max {
+			cut := cutPoint(paragraph, max, true)
+			line := strings.TrimRight(paragraph[:cut], " ")
+			rest := strings.TrimLeft(paragraph[cut:], " ")
+
+			lines = append(lines, line)
+			if rest == "" {
+				paragraph = ""
+				break
+			}
+
+			// Don't let restoring the formatting take up most
+			// of the next line.
+			state := formattingState(line)
+			if 2*len(state) > max {
+				state = ""
+			}
+			paragraph = state + rest
+		}
+
+		if paragraph != "" {
+			lines = append(lines, paragraph)
+		}
+	}
+
+	if maxLines > 0 && len(lines) > maxLines {
+		lines = lines[:maxLines]
+
+		last := lines[maxLines-1]
+		if len(last)+len(truncatedSuffix) > max {
+			last = last[:cutPoint(last, max-len(truncatedSuffix), true)]
+		}
+		lines[maxLines-1] = strings.TrimRight(last, " ") + truncatedSuffix
+	}
+
+	return lines
+}
+
+// cutPoint returns the position where the given text should be
+// cut so that the first part is at most max bytes long. The text
+// is never cut in the middle of a UTF-8 character or a formatting
+// code; if words is true, the text is cut after the last space
+// that fits, if any.
+func cutPoint(text string, max int, words bool) int {
+	hard, soft := 0, 0
+
+	for i := 0; i < len(text); {
+		next := i + tokenLength(text[i:])
+		if next > max {
+			break
+		}
+
+		hard = next
+		if text[i] == ' ' || (next < len(text) && text[next] == ' ') {
+			soft = next
+		}
+		i = next
+	}
+
+	if words && soft > 0 {
+		return soft
+	}
+	if hard == 0 {
+		// Not even a single token fits, so cut it anyway.
+		return max
+	}
+	return hard
+}
+
+// tokenLength returns the length of the first token of the given
+// text, which is either a formatting code, including its arguments,
+// or a single UTF-8 character.
+func tokenLength(text string) int {
+	if n := format.CodeLength(text); n > 0 {
+		return n
+	}
+
+	_, size := utf8.DecodeRuneInString(text)
+	return size
+}
+
+// formattingState returns the formatting codes needed to restore
+// the formatting active at the end of the given text.
+func formattingState(text string) string {
+	toggles := map[byte]bool{}
+	var color string
+
+	for i := 0; i < len(text); {
+		n := tokenLength(text[i:])
+
+		switch c := text[i]; c {
+		case format.BoldCode, format.ItalicCode, format.UnderlineCode, format.StrikethroughCode, format.MonospaceCode, format.ReverseCode:
+			toggles[c] = !toggles[c]
+		case format.ColorCode, format.HexColorCode:
+			color = ""
+			if n > 1 {
+				color = text[i : i+n]
+			}
+		case format.ResetCode:
+			toggles = map[byte]bool{}
+			color = ""
+		}
+
+		i += n
+	}
+
+	var state string
+	for _, c := range []byte{format.BoldCode, format.ItalicCode, format.UnderlineCode, format.StrikethroughCode, format.MonospaceCode, format.ReverseCode} {
+		if toggles[c] {
+			state += string(c)
+		}
+	}
+	return state + color
+}
@@ -0,0 +1,106 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitTextShort(t *testing.T) {
+	assert.Equal(t, []string{"hello there"}, splitText("hello there", 20, 0))
+}
+
+func TestSplitTextAtWordBoundaries(t *testing.T) {
+	lines := splitText("the quick brown fox jumps over the lazy dog", 15, 0)
+
+	assert.Equal(t, []string{"the quick brown", "fox jumps over", "the lazy dog"}, lines)
+}
+
+func TestSplitTextWithoutSpaces(t *testing.T) {
+	lines := splitText(strings.Repeat("a", 25), 10, 0)
+
+	assert.Equal(t, []string{"aaaaaaaaaa", "aaaaaaaaaa", "aaaaa"}, lines)
+}
+
+func TestSplitTextLineBreaks(t *testing.T) {
+	lines := splitText("first\r\nsecond\n\nthird", 100, 0)
+
+	assert.Equal(t, []string{"first", "second", "third"}, lines)
+}
+
+func TestSplitTextUTF8(t *testing.T) {
+	lines := splitText(strings.Repeat("ç", 10), 5, 0)
+
+	for _, line := range lines {
+		assert.True(t, utf8.ValidString(line), "%q", line)
+		assert.True(t, len(line) <= 5, "%q", line)
+	}
+	assert.Equal(t, strings.Repeat("ç", 10), strings.Join(lines, ""))
+}
+
+func TestSplitTextKeepsFormattingCodes(t *testing.T) {
+	lines := splitText("abcdefgh\x0304,12xyz", 10, 0)
+
+	assert.Equal(t, []string{"abcdefgh", "\x0304,12xyz"}, lines)
+}
+
+func TestSplitTextRestoresFormatting(t *testing.T) {
+	lines := splitText("\x02\x0304bold red text\x0f plain", 16, 0)
+
+	assert.Equal(t, []string{"\x02\x0304bold red", "\x02\x0304text\x0f plain"}, lines)
+}
+
+func TestSplitTextMaxLines(t *testing.T) {
+	lines := splitText("one two three four five six", 10, 2)
+
+	assert.Equal(t, []string{"one two", "three…"}, lines)
+	for _, line := range lines {
+		assert.True(t, len(line) <= 10)
+	}
+}
+
+func TestFormattingState(t *testing.T) {
+	assert.Equal(t, "", formattingState("plain"))
+	assert.Equal(t, "\x02", formattingState("\x02bold"))
+	assert.Equal(t, "", formattingState("\x02bold\x02 not"))
+	assert.Equal(t, "\x1d\x0303,01", formattingState("\x1d\x0303,01x"))
+	assert.Equal(t, "", formattingState("\x0303x\x03y"))
+	assert.Equal(t, "", formattingState("\x02\x0303x\x0fy"))
+}
+
+func TestSendMessagesSplitsLongMessages(t *testing.T) {
+	irc, server := newTestIRC(t, Config{FloodInterval: -1})
+	irc.self = Prefix{"gotgotgot", "~gotgotgot", "example.com"}
+
+	// 512 - 2 ("\r\n") - 48 (":gotgotgot!~gotgotgot@example.com PRIVMSG #got :")
+	first := strings.Repeat("a", 462)
+	go irc.SendMessages("#got", first+"b")
+
+	server.expect("PRIVMSG #got :" + first)
+	server.expect("PRIVMSG #got :b")
+}
+
+func TestSendMessagesEstimatesUnknownHost(t *testing.T) {
+	irc, _ := newTestIRC(t, Config{})
+	irc.user = "gotgotgot"
+	irc.self = Prefix{Nick: "gotgotgot"}
+
+	prefix := irc.relayPrefix("PRIVMSG", "#got")
+	assert.Equal(t, ":gotgotgot!~gotgotgot@"+strings.Repeat("x", maxHostLength)+" PRIVMSG #got :", prefix)
+}
+
+func TestTrackSelf(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+
+	server.send(":irc.example.com 001 gotgotgot :Welcome to the network gotgotgot!~got@example.com")
+	server.sync()
+	assert.Equal(t, Prefix{"gotgotgot", "~got", "example.com"}, irc.self)
+
+	server.send(":irc.example.com 001 gotgotgot :Welcome to the network")
+	server.send(":gotgotgot!~got@cloaked/got JOIN #got")
+	server.expect("WHO #got")
+	server.sync()
+	assert.Equal(t, Prefix{"gotgotgot", "~got", "cloaked/got"}, irc.self)
+}
@@ -0,0 +1,418 @@
+package irc
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Numeric replies used to keep track of the channel members.
+const (
+	rplISupport   = "005"
+	rplAway       = "301"
+	rplWhoReply   = "352"
+	rplNamReply   = "353"
+	rplEndOfNames = "366"
+)
+
+// The channel membership prefixes defined in RFC 2812, used
+// until the server advertises its own (ISUPPORT PREFIX).
+const defaultPrefixes = "(ov)@+"
+
+// The channel modes that take a parameter, as defined in
+// RFC 2812, used until the server advertises its own
+// (ISUPPORT CHANMODES).
+const defaultChanModes = "beI,k,l,imnpst"
+
+// User holds what is known about a user who shares
+// a channel with the client.
+type User struct {
+	// The nickname and, once known, the user and host.
+	Prefix
+
+	// Whether the user is marked as away.
+	Away bool
+
+	// The away message, if any.
+	AwayMessage string
+}
+
+// Member is a user in a channel.
+type Member struct {
+	User
+
+	// The membership prefixes of the user in the channel,
+	// highest first (e.g. "@+" for a voiced operator).
+	Prefixes string
+}
+
+// IsOperator checks if the member is a channel operator,
+// or has a higher prefix (e.g. founder or protected).
+func (m Member) IsOperator() bool {
+	return strings.ContainsAny(m.Prefixes, "~&@")
+}
+
+// IsVoiced checks if the member has voice, or any other
+// membership prefix (which also allows speaking in moderated
+// channels).
+func (m Member) IsVoiced() bool {
+	return m.Prefixes != ""
+}
+
+// state keeps track of the channels the client is in,
+// their members, and what is known about each of them.
+type state struct {
+	sync.RWMutex
+
+	// A map where the key is the lowercased nickname,
+	// and the value is the user.
+	users map[string]*User
+
+	// A map where the key is the lowercased channel name,
+	// and the value is the channel.
+	channels map[string]*channelState
+
+	// The channel modes that give membership prefixes, and
+	// their corresponding prefixes, both highest first.
+	prefixModes, prefixes string
+
+	// The channel modes that always take a parameter, and the
+	// ones that only take a parameter when set.
+	paramModes, setParamModes string
+}
+
+// channelState holds the members of a channel.
+type channelState struct {
+	// The channel name, as sent by the server.
+	name string
+
+	// A map where the key is the lowercased nickname,
+	// and the value is the membership prefixes.
+	members map[string]string
+
+	// Whether a NAMES reply is in progress.
+	names bool
+}
+
+// newState creates an empty state.
+func newState() *state {
+	s := &state{}
+	s.reset()
+	return s
+}
+
+// reset forgets everything, as when connecting again.
+func (s *state) reset() {
+	s.users = make(map[string]*User)
+	s.channels = make(map[string]*channelState)
+	s.setPrefixes(defaultPrefixes)
+	s.setChanModes(defaultChanModes)
+}
+
+// handle updates the state according to the given message;
+// self is the current nickname of the client.
+func (s *state) handle(msg Message, self string) {
+	s.Lock()
+	defer s.Unlock()
+
+	if u := s.users[strings.ToLower(msg.Prefix.Nick)]; u != nil && msg.Prefix.Host != "" {
+		u.User, u.Host = msg.Prefix.User, msg.Prefix.Host
+	}
+
+	args := msg.Args()
+
+	switch msg.Command {
+	case rplWelcome:
+		s.reset()
+	case rplISupport:
+		for i := 1; i < len(args)-1; i++ {
+			token := args[i]
+			if strings.HasPrefix(token, "PREFIX=") {
+				s.setPrefixes(strings.TrimPrefix(token, "PREFIX="))
+			} else if strings.HasPrefix(token, "CHANMODES=") {
+				s.setChanModes(strings.TrimPrefix(token, "CHANMODES="))
+			}
+		}
+	case rplNamReply:
+		s.names(msg.Arg(2), msg.Arg(3))
+	case rplEndOfNames:
+		if c := s.channels[strings.ToLower(msg.Arg(1))]; c != nil {
+			c.names = false
+			s.prune()
+		}
+	case rplWhoReply:
+		if u := s.users[strings.ToLower(msg.Arg(5))]; u != nil {
+			u.User, u.Host = msg.Arg(2), msg.Arg(3)
+			u.Away = strings.HasPrefix(msg.Arg(6), "G")
+		}
+	case rplAway:
+		if u := s.users[strings.ToLower(msg.Arg(1))]; u != nil {
+			u.Away, u.AwayMessage = true, msg.Arg(2)
+		}
+	case "AWAY":
+		if u := s.users[strings.ToLower(msg.Prefix.Nick)]; u != nil {
+			u.Away, u.AwayMessage = msg.Arg(0) != "", msg.Arg(0)
+		}
+	case "JOIN":
+		name := msg.Arg(0)
+		if strings.EqualFold(msg.Prefix.Nick, self) {
+			s.channels[strings.ToLower(name)] = &channelState{name: name, members: make(map[string]string)}
+		}
+		if c := s.channels[strings.ToLower(name)]; c != nil {
+			s.add(c, msg.Prefix, "")
+		}
+	case "PART":
+		s.part(msg.Arg(0), msg.Prefix.Nick, self)
+	case "KICK":
+		s.part(msg.Arg(0), msg.Arg(1), self)
+	case "QUIT":
+		nick := strings.ToLower(msg.Prefix.Nick)
+		for _, c := range s.channels {
+			delete(c.members, nick)
+		}
+		delete(s.users, nick)
+	case "NICK":
+		s.rename(msg.Prefix.Nick, msg.Arg(0))
+	case "MODE":
+		if c := s.channels[strings.ToLower(msg.Arg(0))]; c != nil && len(args) > 1 {
+			s.mode(c, args[1], args[2:])
+		}
+	}
+}
+
+// names adds the users listed in a NAMES reply to the given
+// channel. The first reply replaces the previous members.
+func (s *state) names(name, list string) {
+	c := s.channels[strings.ToLower(name)]
+	if c == nil {
+		return
+	}
+
+	if !c.names {
+		c.members = make(map[string]string)
+		c.names = true
+	}
+
+	for _, entry := range strings.Fields(list) {
+		nick := strings.TrimLeft(entry, s.prefixes)
+		s.add(c, ParsePrefix(nick), entry[:len(entry)-len(nick)])
+	}
+}
+
+// add adds the given user to the channel, with the given
+// membership prefixes.
+func (s *state) add(c *channelState, p Prefix, prefixes string) {
+	nick := strings.ToLower(p.Nick)
+
+	u := s.users[nick]
+	if u == nil {
+		u = &User{Prefix: Prefix{Nick: p.Nick}}
+		s.users[nick] = u
+	}
+	if p.Host != "" {
+		u.User, u.Host = p.User, p.Host
+	}
+
+	c.members[nick] = s.sortPrefixes(prefixes)
+}
+
+// part removes the given user from the channel, or the whole
+// channel if the user is the client itself.
+func (s *state) part(name, nick, self string) {
+	c := s.channels[strings.ToLower(name)]
+	if c == nil {
+		return
+	}
+
+	if strings.EqualFold(nick, self) {
+		delete(s.channels, strings.ToLower(name))
+	} else {
+		delete(c.members, strings.ToLower(nick))
+	}
+	s.prune()
+}
+
+// prune forgets the users who no longer share a channel
+// with the client.
+func (s *state) prune() {
+	for nick := range s.users {
+		found := false
+		for _, c := range s.channels {
+			if _, found = c.members[nick]; found {
+				break
+			}
+		}
+		if !found {
+			delete(s.users, nick)
+		}
+	}
+}
+
+// rename updates the nickname of the given user.
+func (s *state) rename(from, to string) {
+	old, key := strings.ToLower(from), strings.ToLower(to)
+
+	u := s.users[old]
+	if u == nil {
+		return
+	}
+	delete(s.users, old)
+	u.Nick = to
+	s.users[key] = u
+
+	for _, c := range s.channels {
+		if prefixes, ok := c.members[old]; ok {
+			delete(c.members, old)
+			c.members[key] = prefixes
+		}
+	}
+}
+
+// mode applies the given channel mode changes to the
+// membership prefixes of the channel members.
+func (s *state) mode(c *channelState, modes string, params []string) {
+	adding := true
+
+	for _, m := range modes {
+		switch {
+		case m == '+' || m == '-':
+			adding = m == '+'
+		case strings.ContainsRune(s.prefixModes, m):
+			if len(params) == 0 {
+				return
+			}
+			nick := strings.ToLower(params[0])
+			params = params[1:]
+
+			prefixes, ok := c.members[nick]
+			if !ok {
+				continue
+			}
+			prefix := s.prefixes[strings.IndexRune(s.prefixModes, m)]
+			if adding {
+				c.members[nick] = s.sortPrefixes(prefixes + string(prefix))
+			} else {
+				c.members[nick] = strings.Replace(prefixes, string(prefix), "", -1)
+			}
+		case strings.ContainsRune(s.paramModes, m) || (adding && strings.ContainsRune(s.setParamModes, m)):
+			if len(params) > 0 {
+				params = params[1:]
+			}
+		}
+	}
+}
+
+// sortPrefixes returns the given membership prefixes without
+// duplicates, highest first.
+func (s *state) sortPrefixes(prefixes string) string {
+	var sorted []byte
+	for i := 0; i < len(s.prefixes); i++ {
+		if strings.IndexByte(prefixes, s.prefixes[i]) >= 0 {
+			sorted = append(sorted, s.prefixes[i])
+		}
+	}
+	return string(sorted)
+}
+
+// setPrefixes sets the membership prefixes from the value of
+// ISUPPORT PREFIX (e.g. "(ov)@+").
+func (s *state) setPrefixes(value string) {
+	i := strings.IndexByte(value, ')')
+	if !strings.HasPrefix(value, "(") || i < 0 || i-1 != len(value)-i-1 {
+		return
+	}
+	s.prefixModes, s.prefixes = value[1:i], value[i+1:]
+}
+
+// setChanModes sets the channel modes that take a parameter
+// from the value of ISUPPORT CHANMODES (e.g. "beI,k,l,imnpst").
+func (s *state) setChanModes(value string) {
+	types := strings.SplitN(value, ",", 4)
+	if len(types) < 3 {
+		return
+	}
+	s.paramModes, s.setParamModes = types[0]+types[1], types[2]
+}
+
+// member returns the given user as a member of the channel.
+func (s *state) member(c *channelState, nick string) (Member, bool) {
+	prefixes, ok := c.members[nick]
+	if !ok {
+		return Member{}, false
+	}
+	return Member{*s.users[nick], prefixes}, true
+}
+
+// requestWho asks the server about the members of the channels
+// the client joins, so that the user and host of the ones already
+// in them, and whether they are away, are known (see rplWhoReply).
+func (irc *IRC) requestWho(msg Message) {
+	if msg.Command == "JOIN" && strings.EqualFold(msg.Prefix.Nick, irc.Nick()) {
+		irc.reply("WHO " + msg.Arg(0))
+	}
+}
+
+// ChannelUsers returns the members of the given channel, sorted
+// by nickname, or nil if the client is not in the channel.
+func (irc *IRC) ChannelUsers(name string) []Member {
+	irc.state.RLock()
+	defer irc.state.RUnlock()
+
+	c := irc.state.channels[strings.ToLower(name)]
+	if c == nil {
+		return nil
+	}
+
+	members := make([]Member, 0, len(c.members))
+	for nick := range c.members {
+		m, _ := irc.state.member(c, nick)
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return strings.ToLower(members[i].Nick) < strings.ToLower(members[j].Nick)
+	})
+	return members
+}
+
+// ChannelMember returns the given user as a member of the given
+// channel, or false if either the user or the client is not in it.
+func (irc *IRC) ChannelMember(name, nick string) (Member, bool) {
+	irc.state.RLock()
+	defer irc.state.RUnlock()
+
+	c := irc.state.channels[strings.ToLower(name)]
+	if c == nil {
+		return Member{}, false
+	}
+	return irc.state.member(c, strings.ToLower(nick))
+}
+
+// User returns what is known about the user with the given
+// nickname, or false if the user shares no channel with the client.
+func (irc *IRC) User(nick string) (User, bool) {
+	irc.state.RLock()
+	defer irc.state.RUnlock()
+
+	if u := irc.state.users[strings.ToLower(nick)]; u != nil {
+		return *u, true
+	}
+	return User{}, false
+}
+
+// UserChannels returns the names of the channels the given user
+// shares with the client, sorted by name.
+func (irc *IRC) UserChannels(nick string) []string {
+	irc.state.RLock()
+	defer irc.state.RUnlock()
+
+	var names []string
+	for _, c := range irc.state.channels {
+		if _, ok := c.members[strings.ToLower(nick)]; ok {
+			names = append(names, c.name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+	return names
+}
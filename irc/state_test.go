@@ -0,0 +1,218 @@
+package irc
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestState creates an IRC value that has joined #got,
+// along with marvin (an operator) and arthur.
+func newTestState(t *testing.T) (*IRC, *testServer) {
+	irc, server := newTestIRC(t, Config{})
+
+	server.send(
+		":irc.example.com 001 got :Welcome",
+		":got!~got@example.com JOIN #got",
+		":irc.example.com 353 got = #got :got @marvin!~marvin@hhgg.org arthur",
+		":irc.example.com 366 got #got :End of /NAMES list.",
+	)
+	server.expect("WHO #got")
+	server.sync()
+
+	return irc, server
+}
+
+func TestStateTracksNames(t *testing.T) {
+	irc, _ := newTestState(t)
+
+	assert.Equal(t, []Member{
+		{User{Prefix: Prefix{"arthur", "", ""}}, ""},
+		{User{Prefix: Prefix{"got", "~got", "example.com"}}, ""},
+		{User{Prefix: Prefix{"marvin", "~marvin", "hhgg.org"}}, "@"},
+	}, irc.ChannelUsers("#GOT"))
+
+	m, ok := irc.ChannelMember("#got", "Marvin")
+	assert.True(t, ok)
+	assert.True(t, m.IsOperator())
+	assert.True(t, m.IsVoiced())
+
+	_, ok = irc.ChannelMember("#got", "zaphod")
+	assert.False(t, ok)
+	assert.Nil(t, irc.ChannelUsers("#beer"))
+}
+
+func TestStateReplacesMembersOnNewNames(t *testing.T) {
+	irc, server := newTestState(t)
+
+	server.send(
+		":irc.example.com 353 got = #got :got +arthur",
+		":irc.example.com 353 got = #got :trillian",
+		":irc.example.com 366 got #got :End of /NAMES list.",
+	)
+	server.sync()
+
+	var nicks []string
+	for _, m := range irc.ChannelUsers("#got") {
+		nicks = append(nicks, m.Prefixes+m.Nick)
+	}
+	assert.Equal(t, []string{"+arthur", "got", "trillian"}, nicks)
+
+	_, ok := irc.User("marvin")
+	assert.False(t, ok)
+}
+
+func TestStateTracksJoinPartAndQuit(t *testing.T) {
+	irc, server := newTestState(t)
+
+	server.send(
+		":got!~got@example.com JOIN #beer",
+		":trillian!~tri@hhgg.org JOIN #got",
+		":trillian!~tri@hhgg.org JOIN #beer",
+		":arthur!~arthur@earth.org PART #got :bye",
+	)
+	server.expect("WHO #beer")
+	server.sync()
+
+	_, ok := irc.User("arthur")
+	assert.False(t, ok)
+	assert.Equal(t, []string{"#beer", "#got"}, irc.UserChannels("trillian"))
+
+	u, _ := irc.User("trillian")
+	assert.Equal(t, Prefix{"trillian", "~tri", "hhgg.org"}, u.Prefix)
+
+	server.send(":trillian!~tri@hhgg.org QUIT :Quit: so long")
+	server.sync()
+
+	_, ok = irc.User("trillian")
+	assert.False(t, ok)
+	assert.Empty(t, irc.UserChannels("trillian"))
+}
+
+func TestStateForgetsChannelWhenLeaving(t *testing.T) {
+	irc, server := newTestState(t)
+
+	server.send(":marvin!~marvin@hhgg.org KICK #got got :go away")
+	server.sync()
+
+	assert.Nil(t, irc.ChannelUsers("#got"))
+	_, ok := irc.User("arthur")
+	assert.False(t, ok)
+}
+
+func TestStateTracksKick(t *testing.T) {
+	irc, server := newTestState(t)
+
+	server.send(":marvin!~marvin@hhgg.org KICK #got arthur :go away")
+	server.sync()
+
+	_, ok := irc.ChannelMember("#got", "arthur")
+	assert.False(t, ok)
+	assert.Len(t, irc.ChannelUsers("#got"), 2)
+}
+
+func TestStateTracksNickChanges(t *testing.T) {
+	irc, server := newTestState(t)
+
+	server.send(":marvin!~marvin@hhgg.org NICK :Marvin42")
+	server.sync()
+
+	m, ok := irc.ChannelMember("#got", "marvin42")
+	assert.True(t, ok)
+	assert.Equal(t, "Marvin42", m.Nick)
+	assert.Equal(t, "@", m.Prefixes)
+
+	_, ok = irc.User("marvin")
+	assert.False(t, ok)
+}
+
+func TestStateTracksModes(t *testing.T) {
+	irc, server := newTestState(t)
+
+	server.send(
+		":irc.example.com 005 got PREFIX=(qaohv)~&@%+ CHANMODES=beI,k,l,imnpst :are supported by this server",
+		":marvin!~marvin@hhgg.org MODE #got +vbko-o arthur *!*@vogon.org secret arthur marvin",
+	)
+	server.sync()
+
+	m, _ := irc.ChannelMember("#got", "arthur")
+	assert.Equal(t, "@+", m.Prefixes)
+
+	m, _ = irc.ChannelMember("#got", "marvin")
+	assert.Equal(t, "", m.Prefixes)
+	assert.False(t, m.IsOperator())
+
+	server.send(
+		":marvin!~marvin@hhgg.org MODE #got -l+q arthur",
+		":irc.example.com 353 got = #got :~@arthur %marvin",
+	)
+	server.sync()
+
+	m, _ = irc.ChannelMember("#got", "arthur")
+	assert.Equal(t, "~@", m.Prefixes)
+	assert.True(t, m.IsOperator())
+
+	m, _ = irc.ChannelMember("#got", "marvin")
+	assert.Equal(t, "%", m.Prefixes)
+}
+
+func TestStateTracksAway(t *testing.T) {
+	irc, server := newTestState(t)
+
+	server.send(
+		":marvin!~marvin@hhgg.org AWAY :Brain the size of a planet",
+		":irc.example.com 352 got #got ~arthur earth.org irc.example.com arthur G :0 Arthur Dent",
+	)
+	server.sync()
+
+	u, _ := irc.User("marvin")
+	assert.True(t, u.Away)
+	assert.Equal(t, "Brain the size of a planet", u.AwayMessage)
+
+	u, _ = irc.User("arthur")
+	assert.True(t, u.Away)
+	assert.Equal(t, Prefix{"arthur", "~arthur", "earth.org"}, u.Prefix)
+
+	server.send(":marvin!~marvin@hhgg.org AWAY")
+	server.sync()
+
+	u, _ = irc.User("marvin")
+	assert.False(t, u.Away)
+	assert.Equal(t, "", u.AwayMessage)
+}
+
+func TestStateRequestsWhoOnJoin(t *testing.T) {
+	irc, server := newTestState(t)
+
+	server.send(
+		":got!~got@example.com JOIN #beer",
+		":irc.example.com 353 got = #beer :got zaphod ford",
+		":irc.example.com 366 got #beer :End of /NAMES list.",
+	)
+	server.expect("WHO #beer")
+
+	server.send(
+		":irc.example.com 352 got #beer ~got example.com irc.example.com got H :0 got",
+		":irc.example.com 352 got #beer ~zaphod betelgeuse.org irc.example.com zaphod G :0 Zaphod Beeblebrox",
+		":irc.example.com 352 got #beer ~ford betelgeuse.org irc.example.com ford H@ :0 Ford Prefect",
+		":irc.example.com 315 got #beer :End of /WHO list.",
+	)
+	server.sync()
+
+	u, _ := irc.User("zaphod")
+	assert.Equal(t, Prefix{"zaphod", "~zaphod", "betelgeuse.org"}, u.Prefix)
+	assert.True(t, u.Away)
+
+	u, _ = irc.User("ford")
+	assert.Equal(t, Prefix{"ford", "~ford", "betelgeuse.org"}, u.Prefix)
+	assert.False(t, u.Away)
+}
+
+func TestStateResetsOnWelcome(t *testing.T) {
+	irc, server := newTestState(t)
+
+	server.send(":irc.example.com 001 got :Welcome back")
+	server.sync()
+
+	assert.Nil(t, irc.ChannelUsers("#got"))
+}
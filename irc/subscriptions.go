@@ -0,0 +1,229 @@
+package irc
+
+import (
+	"log"
+	"sync"
+)
+
+// Delivery defines how messages are delivered to a subscription
+// channel when the subscriber doesn't keep up.
+type Delivery int
+
+const (
+	// Block waits for the subscriber to receive each message,
+	// which holds up the handling of further messages (including
+	// PINGs) for everyone until it does. It's the default.
+	Block Delivery = iota
+
+	// DropOnFull drops the messages that don't fit in the
+	// channel buffer.
+	DropOnFull
+
+	// Buffered queues the messages the subscriber is not ready
+	// to receive, without limit, so that none is dropped and the
+	// client never waits.
+	Buffered
+)
+
+// Subscription is a message subscription, as created by Subscribe.
+type Subscription struct {
+	// The filter messages need to match in order
+	// to be delivered.
+	filter Filter
+
+	// The channel where messages are delivered.
+	channel chan Message
+
+	// How messages are delivered.
+	delivery Delivery
+
+	// Guards the fields below; held while delivering,
+	// so that the channel isn't closed meanwhile.
+	mu sync.Mutex
+
+	// Whether the subscription has been cancelled.
+	cancelled bool
+
+	// The messages waiting to be delivered, with Buffered.
+	queue []Message
+
+	// Signals the forwarding goroutine that messages are
+	// queued, with Buffered.
+	queued chan struct{}
+
+	// Closed when the subscription is cancelled.
+	done chan struct{}
+
+	// Tracks the forwarding goroutine, with Buffered.
+	forwarding sync.WaitGroup
+
+	// Makes sure the subscription is cancelled only once.
+	cancelOnce sync.Once
+
+	// The registry the subscription belongs to.
+	subscriptions *subscriptions
+}
+
+// Unsubscribe cancels the subscription: no more messages are
+// delivered, the ones queued are dropped, and the channel is
+// closed.
+func (s *Subscription) Unsubscribe() {
+	s.cancelOnce.Do(func() {
+		s.subscriptions.remove(s)
+		close(s.done)
+		s.forwarding.Wait()
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.cancelled = true
+		s.queue = nil
+		close(s.channel)
+	})
+}
+
+// deliver delivers the given message, if it matches the filter,
+// according to the subscription delivery mode. With Block, it
+// gives up if the given channel is closed.
+func (s *Subscription) deliver(msg Message, done chan struct{}) {
+	if !s.filter(msg) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.cancelled {
+		return
+	}
+
+	switch s.delivery {
+	case DropOnFull:
+		select {
+		case s.channel <- msg:
+		default:
+			log.Printf("[IRC] Subscriber is too slow, dropping %s message\n", msg.Command)
+		}
+	case Buffered:
+		s.queue = append(s.queue, msg)
+		select {
+		case s.queued <- struct{}{}:
+		default:
+		}
+	default:
+		select {
+		case s.channel <- msg:
+		case <-s.done:
+		case <-done:
+		}
+	}
+}
+
+// forward delivers the queued messages, with Buffered,
+// until the subscription is cancelled.
+func (s *Subscription) forward() {
+	defer s.forwarding.Done()
+
+	for {
+		s.mu.Lock()
+		var msg Message
+		next := len(s.queue) > 0
+		if next {
+			msg = s.queue[0]
+			s.queue = s.queue[1:]
+		}
+		s.mu.Unlock()
+
+		if !next {
+			select {
+			case <-s.queued:
+				continue
+			case <-s.done:
+				return
+			}
+		}
+
+		select {
+		case s.channel <- msg:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// subscriptions holds the message subscriptions.
+type subscriptions struct {
+	sync.RWMutex
+
+	// The subscriptions, in the order they were created.
+	list []*Subscription
+}
+
+// add creates a subscription.
+func (r *subscriptions) add(filter Filter, channel chan Message, delivery Delivery) *Subscription {
+	s := &Subscription{
+		filter:        filter,
+		channel:       channel,
+		delivery:      delivery,
+		queued:        make(chan struct{}, 1),
+		done:          make(chan struct{}),
+		subscriptions: r,
+	}
+
+	if delivery == Buffered {
+		s.forwarding.Add(1)
+		go s.forward()
+	}
+
+	r.Lock()
+	defer r.Unlock()
+
+	r.list = append(r.list, s)
+	return s
+}
+
+// remove removes the given subscription.
+func (r *subscriptions) remove(s *Subscription) {
+	r.Lock()
+	defer r.Unlock()
+
+	for i, other := range r.list {
+		if other == s {
+			r.list = append(r.list[:i:i], r.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// all returns the current subscriptions.
+func (r *subscriptions) all() []*Subscription {
+	r.RLock()
+	defer r.RUnlock()
+
+	return append([]*Subscription{}, r.list...)
+}
+
+// dispatch delivers the given message to the subscriptions whose
+// filters match it. With Block, it gives up if the given channel
+// is closed.
+func (r *subscriptions) dispatch(msg Message, done chan struct{}) {
+	for _, s := range r.all() {
+		s.deliver(msg, done)
+	}
+}
+
+// Subscribe configures a message subscription filter that,
+// when matched, causes the parsed message to be sent to the
+// specified channel, waiting for the subscriber to receive it
+// (see Block). The channel is closed once the subscription is
+// cancelled, or the client is closed, so subscribers must not
+// close it themselves; a channel must not be subscribed twice.
+func (irc *IRC) Subscribe(filter Filter, channel chan Message) *Subscription {
+	return irc.SubscribeWith(filter, channel, Block)
+}
+
+// SubscribeWith works like Subscribe, delivering messages
+// according to the given delivery mode.
+func (irc *IRC) SubscribeWith(filter Filter, channel chan Message, delivery Delivery) *Subscription {
+	return irc.subscriptions.add(filter, channel, delivery)
+}
@@ -0,0 +1,97 @@
+package irc
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const hello = ":marvin!~marvin@hhgg.org PRIVMSG #got :hello?"
+
+func TestUnsubscribe(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+	defer irc.Close()
+
+	messages := make(chan Message, 1)
+	s := irc.Subscribe(MatchCommand("PRIVMSG"), messages)
+
+	server.send(hello)
+	assert.Equal(t, "hello?", (<-messages).Trailing)
+
+	s.Unsubscribe()
+	s.Unsubscribe()
+
+	server.send(hello)
+	server.sync()
+
+	_, ok := <-messages
+	assert.False(t, ok)
+}
+
+func TestUnsubscribeWhileBlocked(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+	defer irc.Close()
+
+	// Nobody reads the subscription, so the reader blocks
+	// until the subscription is cancelled.
+	messages := make(chan Message)
+	s := irc.Subscribe(MatchCommand("PRIVMSG"), messages)
+
+	server.send(hello)
+	s.Unsubscribe()
+	server.sync()
+}
+
+func TestSubscribeDropOnFull(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+	defer irc.Close()
+
+	messages := make(chan Message, 1)
+	irc.SubscribeWith(MatchCommand("PRIVMSG"), messages, DropOnFull)
+
+	server.send(hello, hello, hello)
+	server.sync()
+
+	assert.Len(t, messages, 1)
+}
+
+func TestSubscribeBuffered(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+	defer irc.Close()
+
+	messages := make(chan Message)
+	irc.SubscribeWith(MatchCommand("PRIVMSG"), messages, Buffered)
+
+	server.send(
+		":marvin!~marvin@hhgg.org PRIVMSG #got :one",
+		":marvin!~marvin@hhgg.org PRIVMSG #got :two",
+		":marvin!~marvin@hhgg.org PRIVMSG #got :three",
+	)
+	server.sync()
+
+	assert.Equal(t, "one", (<-messages).Trailing)
+	assert.Equal(t, "two", (<-messages).Trailing)
+	assert.Equal(t, "three", (<-messages).Trailing)
+}
+
+func TestSubscribeConcurrently(t *testing.T) {
+	irc, server := newTestIRC(t, Config{})
+	defer irc.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			messages := make(chan Message, 10)
+			s := irc.SubscribeWith(MatchCommand("PRIVMSG"), messages, DropOnFull)
+			s.Unsubscribe()
+		}()
+	}
+
+	server.send(hello, hello, hello)
+	server.sync()
+	wg.Wait()
+}
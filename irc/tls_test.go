@@ -0,0 +1,244 @@
+package irc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testCert is a certificate generated for tests, along with
+// its PEM encoded files.
+type testCert struct {
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	tls      tls.Certificate
+	certFile string
+	keyFile  string
+}
+
+// newTestCert generates a certificate for the given DNS name, signed
+// by parent (or self-signed, if parent is nil), and writes it to dir.
+func newTestCert(t *testing.T, dir, name string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDer, _ := x509.MarshalECPrivateKey(key)
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	tc := &testCert{
+		cert:     cert,
+		key:      key,
+		certFile: filepath.Join(dir, name+".crt"),
+		keyFile:  filepath.Join(dir, name+".key"),
+	}
+	tc.tls, _ = tls.X509KeyPair(certPem, keyPem)
+	ioutil.WriteFile(tc.certFile, certPem, 0600)
+	ioutil.WriteFile(tc.keyFile, keyPem, 0600)
+
+	return tc
+}
+
+// tlsFixture holds a local TLS listener and the certificates
+// used to set it up.
+type tlsFixture struct {
+	listener net.Listener
+	ca       *testCert
+	client   *testCert
+	port     int
+
+	// Receives the SNI server name and the client certificates
+	// presented in each handshake.
+	handshakes chan *tls.ConnectionState
+}
+
+func newTLSFixture(t *testing.T) *tlsFixture {
+	dir, err := ioutil.TempDir("", "got-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := newTestCert(t, dir, "ca.got.test", nil)
+	server := newTestCert(t, dir, "irc.got.test", ca)
+	client := newTestCert(t, dir, "client.got.test", ca)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{server.tls},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    pool,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := &tlsFixture{
+		listener:   listener,
+		ca:         ca,
+		client:     client,
+		handshakes: make(chan *tls.ConnectionState, 1),
+	}
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	f.port, _ = strconv.Atoi(port)
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			tlsConn := conn.(*tls.Conn)
+			if err := tlsConn.Handshake(); err == nil {
+				state := tlsConn.ConnectionState()
+				f.handshakes <- &state
+			}
+			conn.Close()
+		}
+	}()
+
+	t.Cleanup(func() {
+		listener.Close()
+		os.RemoveAll(dir)
+	})
+
+	return f
+}
+
+func (f *tlsFixture) config() Config {
+	return Config{
+		Server:        "127.0.0.1",
+		Port:          f.port,
+		TLS:           true,
+		TLSServerName: "irc.got.test",
+		TLSCAFile:     f.ca.certFile,
+	}
+}
+
+func TestDialTLSWithCustomCA(t *testing.T) {
+	f := newTLSFixture(t)
+
+	conn, err := dial(f.config())
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	state := <-f.handshakes
+	assert.Equal(t, "irc.got.test", state.ServerName)
+	assert.Empty(t, state.PeerCertificates)
+}
+
+func TestDialTLSFailsWithUnknownCA(t *testing.T) {
+	f := newTLSFixture(t)
+	config := f.config()
+	config.TLSCAFile = ""
+
+	_, err := dial(config)
+	assert.NotNil(t, err)
+}
+
+func TestDialTLSFailsWithWrongServerName(t *testing.T) {
+	f := newTLSFixture(t)
+	config := f.config()
+	config.TLSServerName = "other.got.test"
+
+	_, err := dial(config)
+	assert.NotNil(t, err)
+}
+
+func TestDialTLSInsecure(t *testing.T) {
+	f := newTLSFixture(t)
+	config := f.config()
+	config.TLSCAFile = ""
+	config.TLSInsecure = true
+
+	conn, err := dial(config)
+	assert.Nil(t, err)
+	conn.Close()
+}
+
+func TestDialTLSWithClientCertificate(t *testing.T) {
+	f := newTLSFixture(t)
+	config := f.config()
+	config.TLSCertFile = f.client.certFile
+	config.TLSKeyFile = f.client.keyFile
+
+	conn, err := dial(config)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	// Read until the server closes the connection, so the
+	// handshake is guaranteed to have completed on both ends.
+	ioutil.ReadAll(conn)
+
+	state := <-f.handshakes
+	if assert.Len(t, state.PeerCertificates, 1) {
+		assert.Equal(t, "client.got.test", state.PeerCertificates[0].Subject.CommonName)
+	}
+}
+
+func TestTLSConfigWithMissingCAFile(t *testing.T) {
+	config := Config{TLS: true, TLSCAFile: "/does/not/exist.pem"}
+
+	_, err := config.tlsConfig()
+	assert.NotNil(t, err)
+}
+
+func TestNewIRCFailsWithInvalidTLSConfig(t *testing.T) {
+	_, config := newTestListener(t)
+	config.TLS = true
+	config.TLSCertFile = "/does/not/exist.pem"
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := NewIRC(config)
+		result <- err
+	}()
+
+	select {
+	case err := <-result:
+		assert.Contains(t, err.Error(), "client certificate")
+	case <-time.After(time.Second):
+		t.Fatal("NewIRC retried an invalid configuration")
+	}
+}
@@ -0,0 +1,177 @@
+package irc
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// How long to wait for a TCP connection to be established.
+const dialTimeout = 30 * time.Second
+
+// How long to wait for a handshake (TLS, proxy or WebSocket) to
+// complete once connected; a variable so that tests can shorten it.
+var handshakeTimeout = dialTimeout
+
+// Dialer opens connections to IRC servers. It's satisfied by
+// *net.Dialer, as well as by the dialers in this package, some of
+// which wrap another Dialer (e.g. TLS over a proxy connection).
+type Dialer interface {
+	// Dial connects to the given address on the named network.
+	Dial(network, address string) (net.Conn, error)
+}
+
+// TLSDialer is a Dialer that establishes TLS connections
+// on top of the connections made by another Dialer.
+type TLSDialer struct {
+	// The Dialer used to establish the underlying connections.
+	// If nil, connections are made over TCP.
+	Dialer Dialer
+
+	// The TLS configuration. If the server name is not set,
+	// the host of the dialed address is used.
+	Config *tls.Config
+}
+
+// Dial connects to the given address and completes
+// the TLS handshake.
+func (d *TLSDialer) Dial(network, address string) (net.Conn, error) {
+	conn, err := forward(d.Dialer).Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	config := d.Config
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(address)
+	}
+
+	tlsConn := tls.Client(conn, config)
+	if err := handshake(conn, tlsConn.Handshake); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// UnixDialer is a Dialer that connects to a server listening
+// on a Unix socket, regardless of the dialed address.
+type UnixDialer struct {
+	// The path to the Unix socket.
+	Path string
+}
+
+// Dial connects to the Unix socket.
+func (d *UnixDialer) Dial(network, address string) (net.Conn, error) {
+	return net.Dial("unix", d.Path)
+}
+
+// ErrPipeClosed is returned when dialing a closed PipeDialer.
+var ErrPipeClosed = errors.New("irc: pipe closed")
+
+// PipeDialer is a Dialer that connects to an in-memory server,
+// regardless of the dialed address, using net.Pipe. It's also the
+// net.Listener the server uses to accept those connections.
+type PipeDialer struct {
+	// The server ends of the connections being dialed.
+	conns chan net.Conn
+
+	// Closed when the dialer is closed.
+	done chan struct{}
+
+	// Makes sure the dialer is closed only once.
+	closeOnce sync.Once
+}
+
+// NewPipeDialer creates an in-memory dialer.
+func NewPipeDialer() *PipeDialer {
+	return &PipeDialer{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Dial creates an in-memory connection, and waits for
+// the server end to be accepted.
+func (d *PipeDialer) Dial(network, address string) (net.Conn, error) {
+	client, server := net.Pipe()
+
+	select {
+	case d.conns <- server:
+		return client, nil
+	case <-d.done:
+		return nil, ErrPipeClosed
+	}
+}
+
+// Accept waits for the next connection to be dialed,
+// and returns its server end.
+func (d *PipeDialer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-d.conns:
+		return conn, nil
+	case <-d.done:
+		return nil, ErrPipeClosed
+	}
+}
+
+// Close stops accepting connections; dialing fails afterwards.
+// Connections already established are not closed.
+func (d *PipeDialer) Close() error {
+	d.closeOnce.Do(func() { close(d.done) })
+	return nil
+}
+
+// Addr returns the address of the in-memory server.
+func (d *PipeDialer) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+// pipeAddr is the address of in-memory connections.
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// handshake runs the given handshake over the given connection,
+// failing if it doesn't complete within handshakeTimeout, so that
+// a server or proxy that stops responding doesn't block dialing.
+func handshake(conn net.Conn, f func() error) error {
+	if err := conn.SetDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return err
+	}
+	if err := f(); err != nil {
+		return err
+	}
+	return conn.SetDeadline(time.Time{})
+}
+
+// forward returns the given Dialer, or a TCP one if nil.
+func forward(d Dialer) Dialer {
+	if d == nil {
+		return &net.Dialer{Timeout: dialTimeout}
+	}
+	return d
+}
+
+// dialer returns the Dialer used to connect to the configured
+// server: the configured one, or TCP by default, with TLS on top
+// if enabled. It returns an error if the TLS settings are invalid.
+func dialer(config Config) (Dialer, error) {
+	d := forward(config.Dialer)
+
+	if config.TLS {
+		tlsConfig, err := config.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		d = &TLSDialer{d, tlsConfig}
+	}
+	return d, nil
+}
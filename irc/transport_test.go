@@ -0,0 +1,175 @@
+package irc
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// dial opens a connection to the configured server,
+// with a single attempt.
+func dial(config Config) (net.Conn, error) {
+	d, err := dialer(config)
+	if err != nil {
+		return nil, err
+	}
+	return d.Dial("tcp", config.address())
+}
+
+func TestNewIRCWithPipeDialer(t *testing.T) {
+	dialer := NewPipeDialer()
+	defer dialer.Close()
+
+	subscribed := make(chan bool)
+	go func() {
+		conn, err := dialer.Accept()
+		if assert.Nil(t, err) {
+			<-subscribed
+			conn.Write([]byte(":irc.example.com NOTICE * :*** Looking up your hostname...\r\n"))
+		}
+	}()
+
+	irc, err := NewIRC(Config{Server: "irc.example.com", Port: 6667, Dialer: dialer})
+	assert.Nil(t, err)
+
+	notices := make(chan Message, 1)
+	irc.Subscribe(MatchCommand("NOTICE"), notices)
+	close(subscribed)
+
+	msg := <-notices
+	assert.Equal(t, "*** Looking up your hostname...", msg.Trailing)
+}
+
+func TestReconnectWithPipeDialer(t *testing.T) {
+	dialer := NewPipeDialer()
+	defer dialer.Close()
+
+	result := make(chan *IRC)
+	go func() {
+		irc, _ := NewIRC(Config{Dialer: dialer, ReconnectDelay: time.Millisecond})
+		result <- irc
+	}()
+
+	conn, _ := dialer.Accept()
+	irc := <-result
+
+	disconnected := make(chan error, 1)
+	irc.OnDisconnect(func(err error) { disconnected <- err })
+
+	conn.Close()
+	assert.NotNil(t, <-disconnected)
+
+	conn, err := dialer.Accept()
+	assert.Nil(t, err)
+
+	server := &testServer{t, conn, bufio.NewReader(conn)}
+	server.expect("CAP LS 302")
+}
+
+func TestPipeDialerClosed(t *testing.T) {
+	dialer := NewPipeDialer()
+	dialer.Close()
+
+	_, err := dialer.Dial("tcp", "irc.example.com:6667")
+	assert.Equal(t, ErrPipeClosed, err)
+
+	_, err = dialer.Accept()
+	assert.Equal(t, ErrPipeClosed, err)
+
+	_, err = NewIRC(Config{Dialer: dialer, ReconnectMaxAttempts: 1})
+	assert.NotNil(t, err)
+}
+
+func TestUnixDialer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "got-unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "irc.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets not supported: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Write([]byte("PING :irc.example.com\r\n"))
+			bufio.NewReader(conn).ReadString('\n')
+			conn.Close()
+		}
+	}()
+
+	conn, err := dial(Config{Server: "ignored", Port: 6667, Dialer: &UnixDialer{path}})
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, "PING :irc.example.com\r\n", line)
+}
+
+func TestTLSOverCustomDialer(t *testing.T) {
+	f := newTLSFixture(t)
+	config := f.config()
+
+	dialed := make(chan string, 1)
+	config.Dialer = dialerFunc(func(network, address string) (net.Conn, error) {
+		dialed <- address
+		return net.Dial(network, address)
+	})
+
+	conn, err := dial(config)
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	assert.Equal(t, config.address(), <-dialed)
+	assert.Equal(t, "irc.got.test", (<-f.handshakes).ServerName)
+}
+
+// dialerFunc adapts a function to the Dialer interface.
+type dialerFunc func(network, address string) (net.Conn, error)
+
+func (f dialerFunc) Dial(network, address string) (net.Conn, error) {
+	return f(network, address)
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { handshakeTimeout = timeout }(handshakeTimeout)
+	handshakeTimeout = 50 * time.Millisecond
+
+	// A server or proxy that accepts connections and says nothing.
+	listener, config := newTestListener(t)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	address := config.address()
+
+	dialers := map[string]Dialer{
+		"TLS":        &TLSDialer{},
+		"SOCKS5":     &SOCKS5Dialer{Address: address},
+		"HTTP proxy": &HTTPProxyDialer{Address: address},
+		"WebSocket":  &WebSocketDialer{},
+	}
+	for name, d := range dialers {
+		start := time.Now()
+		_, err := d.Dial("tcp", address)
+
+		assert.NotNil(t, err, name)
+		assert.True(t, time.Since(start) < time.Second, name)
+	}
+}
@@ -0,0 +1,421 @@
+package irc
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The IRCv3 WebSocket subprotocols: with text, each message is sent
+// in a text frame (and must be valid UTF-8); with binary, in a
+// binary frame.
+const (
+	WebSocketText   = "text.ircv3.net"
+	WebSocketBinary = "binary.ircv3.net"
+)
+
+// The GUID used to compute the Sec-WebSocket-Accept header,
+// as defined in RFC 6455 (section 1.3).
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// The largest WebSocket message accepted; IRC messages are much
+// shorter, even with tags.
+const maxWebSocketMessage = 64 * 1024
+
+// How long to wait for the close frame to be sent
+// when closing a WebSocket connection.
+const closeFrameTimeout = time.Second
+
+// WebSocket frame opcodes.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xa
+)
+
+// ErrWebSocketHandshake is returned when the server does not
+// accept the WebSocket connection.
+var ErrWebSocketHandshake = errors.New("irc: WebSocket handshake failed")
+
+// WebSocketDialer is a Dialer that connects to servers exposing
+// the IRCv3 WebSocket transport, over ws:// or wss://. Each IRC
+// message is sent and received in its own WebSocket message;
+// the resulting connection reads and writes lines as usual.
+type WebSocketDialer struct {
+	// The URL of the WebSocket endpoint (e.g. wss://irc.example.com/).
+	// If empty, ws:// is used with the dialed address.
+	URL string
+
+	// The subprotocols to offer, in order of preference. Defaults
+	// to WebSocketText, then WebSocketBinary. If the server picks
+	// none, binary frames are used.
+	Protocols []string
+
+	// The TLS configuration used with wss://. If the server name
+	// is not set, the host of the URL is used.
+	TLSConfig *tls.Config
+
+	// The Origin header sent with the handshake, if any.
+	Origin string
+
+	// The Dialer used to establish the underlying connections.
+	// If nil, connections are made over TCP.
+	Dialer Dialer
+}
+
+// Dial connects to the WebSocket endpoint and completes the
+// WebSocket handshake. The given address is only used if
+// no URL is set.
+func (d *WebSocketDialer) Dial(network, address string) (net.Conn, error) {
+	rawURL := d.URL
+	if rawURL == "" {
+		rawURL = "ws://" + address + "/"
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var transport Dialer
+	switch u.Scheme {
+	case "ws":
+		transport = forward(d.Dialer)
+	case "wss":
+		transport = &TLSDialer{d.Dialer, d.TLSConfig}
+	default:
+		return nil, fmt.Errorf("irc: unsupported WebSocket scheme %q", u.Scheme)
+	}
+
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"ws": "80", "wss": "443"}[u.Scheme]
+	}
+
+	conn, err := transport.Dial(network, net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+
+	var ws net.Conn
+	err = handshake(conn, func() (err error) {
+		ws, err = d.handshake(conn, u)
+		return err
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+// handshake upgrades the given connection to a WebSocket.
+func (d *WebSocketDialer) handshake(conn net.Conn, u *url.URL) (net.Conn, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	protocols := d.Protocols
+	if len(protocols) == 0 {
+		protocols = []string{WebSocketText, WebSocketBinary}
+	}
+
+	req := &http.Request{
+		Method: http.MethodGet,
+		URL:    &url.URL{Path: u.Path, RawQuery: u.RawQuery},
+		Host:   u.Host,
+		Header: make(http.Header),
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Protocol", strings.Join(protocols, ", "))
+	if d.Origin != "" {
+		req.Header.Set("Origin", d.Origin)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	buf := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(buf, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return nil, fmt.Errorf("%v: %s", ErrWebSocketHandshake, resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		return nil, fmt.Errorf("%v: invalid Sec-WebSocket-Accept", ErrWebSocketHandshake)
+	}
+
+	protocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if protocol != "" && !contains(protocols, protocol) {
+		return nil, fmt.Errorf("%v: unexpected subprotocol %q", ErrWebSocketHandshake, protocol)
+	}
+
+	return &webSocketConn{Conn: conn, buf: buf, text: protocol == WebSocketText}, nil
+}
+
+// webSocketAccept computes the Sec-WebSocket-Accept header
+// expected in reply to the given Sec-WebSocket-Key.
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// contains checks if the given list contains the given value.
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// webSocketConn is a client WebSocket connection which reads each
+// message as a line, terminated by "\r\n", and sends each line
+// written as a message.
+type webSocketConn struct {
+	net.Conn
+
+	// The buffer the connection is read from.
+	buf *bufio.Reader
+
+	// Whether text frames are used, rather than binary ones.
+	text bool
+
+	// The lines read, waiting to be returned by Read.
+	in bytes.Buffer
+
+	// Guards the fields below, and writing frames.
+	mu sync.Mutex
+
+	// The last line written, until it's complete.
+	out []byte
+
+	// Whether a close frame was sent.
+	closed bool
+}
+
+// Read reads the messages received as lines.
+func (c *webSocketConn) Read(b []byte) (int, error) {
+	for c.in.Len() == 0 {
+		msg, err := c.readMessage()
+		if err != nil {
+			return 0, err
+		}
+		c.in.Write(bytes.TrimRight(msg, "\r\n"))
+		c.in.WriteString("\r\n")
+	}
+	return c.in.Read(b)
+}
+
+// readMessage reads the next data message, replying to any
+// control frames received before it.
+func (c *webSocketConn) readMessage() ([]byte, error) {
+	var msg []byte
+	for {
+		fin, opcode, payload, err := readFrame(c.buf)
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case wsPing:
+			if err := c.writeControl(wsPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			c.writeControl(wsClose, nil)
+			return nil, io.EOF
+		}
+
+		msg = append(msg, payload...)
+		if len(msg) > maxWebSocketMessage {
+			return nil, errors.New("irc: WebSocket message too long")
+		}
+		if fin {
+			return msg, nil
+		}
+	}
+}
+
+// textOnly checks if text frames are used, which
+// can only carry UTF-8.
+func (c *webSocketConn) textOnly() bool {
+	return c.text
+}
+
+// Write sends each complete line written as a message. Incomplete
+// lines are kept until the rest of them is written.
+func (c *webSocketConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	opcode := byte(wsBinary)
+	if c.text {
+		opcode = wsText
+	}
+
+	c.out = append(c.out, b...)
+	for {
+		i := bytes.IndexByte(c.out, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := bytes.TrimRight(c.out[:i], "\r")
+		if c.text {
+			line = bytes.ToValidUTF8(line, []byte("�"))
+		}
+		if err := writeFrame(c.Conn, opcode, line, true); err != nil {
+			return 0, err
+		}
+		c.out = c.out[i+1:]
+	}
+	return len(b), nil
+}
+
+// Close sends a close frame, unless one was sent already or a
+// write is in progress, and closes the connection.
+func (c *webSocketConn) Close() error {
+	if c.mu.TryLock() {
+		if !c.closed {
+			c.closed = true
+			c.Conn.SetWriteDeadline(time.Now().Add(closeFrameTimeout))
+			writeFrame(c.Conn, wsClose, nil, true)
+		}
+		c.mu.Unlock()
+	}
+	return c.Conn.Close()
+}
+
+// writeControl sends a control frame. Only one close frame is sent.
+func (c *webSocketConn) writeControl(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if opcode == wsClose {
+		if c.closed {
+			return nil
+		}
+		c.closed = true
+	}
+	return writeFrame(c.Conn, opcode, payload, true)
+}
+
+// readFrame reads a single WebSocket frame, unmasking
+// its payload if needed.
+func readFrame(r io.Reader) (fin bool, opcode byte, payload []byte, err error) {
+	header := make([]byte, 2)
+	if _, err = io.ReadFull(r, header); err != nil {
+		return
+	}
+	fin, opcode = header[0]&0x80 != 0, header[0]&0x0f
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err = io.ReadFull(r, ext); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err = io.ReadFull(r, ext); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > maxWebSocketMessage {
+		err = errors.New("irc: WebSocket frame too long")
+		return
+	}
+
+	var mask []byte
+	if header[1]&0x80 != 0 {
+		mask = make([]byte, 4)
+		if _, err = io.ReadFull(r, mask); err != nil {
+			return
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	for i := range mask {
+		for j := i; j < len(payload); j += 4 {
+			payload[j] ^= mask[i]
+		}
+	}
+	return
+}
+
+// writeFrame writes the given payload in a single, final
+// WebSocket frame, masked if required (i.e. by clients).
+func writeFrame(w io.Writer, opcode byte, payload []byte, masked bool) error {
+	frame := []byte{0x80 | opcode}
+
+	var maskBit byte
+	if masked {
+		maskBit = 0x80
+	}
+
+	switch n := len(payload); {
+	case n < 126:
+		frame = append(frame, maskBit|byte(n))
+	case n <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(n))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(n))
+	}
+
+	if !masked {
+		_, err := w.Write(append(frame, payload...))
+		return err
+	}
+
+	mask := make([]byte, 4)
+	if _, err := rand.Read(mask); err != nil {
+		return err
+	}
+	frame = append(frame, mask...)
+	for i, b := range payload {
+		frame = append(frame, b^mask[i%4])
+	}
+
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,223 @@
+package irc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webSocketPeer is the server end of a WebSocket connection.
+type webSocketPeer struct {
+	t    *testing.T
+	conn net.Conn
+	buf  *bufio.Reader
+}
+
+// expect reads a frame sent by the client, which must be masked.
+func (p *webSocketPeer) expect(opcode byte, payload string) {
+	fin, op, data, err := readFrame(p.buf)
+	assert.Nil(p.t, err)
+	assert.True(p.t, fin)
+	assert.Equal(p.t, opcode, op)
+	assert.Equal(p.t, payload, string(data))
+}
+
+// send sends a frame to the client.
+func (p *webSocketPeer) send(fin bool, opcode byte, payload string) {
+	frame := []byte{opcode, byte(len(payload))}
+	if fin {
+		frame[0] |= 0x80
+	}
+	p.conn.Write(append(frame, payload...))
+}
+
+// webSocketServer starts an HTTP server that accepts WebSocket
+// connections on /irc, picking the given subprotocol, and hands
+// their server ends over.
+func webSocketServer(t *testing.T, protocol string, secure bool) (*httptest.Server, chan *webSocketPeer) {
+	peers := make(chan *webSocketPeer, 1)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/irc" || r.Header.Get("Upgrade") != "websocket" {
+			http.NotFound(w, r)
+			return
+		}
+		assert.Equal(t, "13", r.Header.Get("Sec-WebSocket-Version"))
+		assert.Equal(t, WebSocketText+", "+WebSocketBinary, r.Header.Get("Sec-WebSocket-Protocol"))
+
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if !assert.Nil(t, err) {
+			return
+		}
+
+		conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Accept: " + webSocketAccept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n" +
+			"Sec-WebSocket-Protocol: " + protocol + "\r\n\r\n"))
+
+		peers <- &webSocketPeer{t, conn, buf.Reader}
+	})
+
+	if secure {
+		return httptest.NewTLSServer(handler), peers
+	}
+	return httptest.NewServer(handler), peers
+}
+
+// webSocketURL returns the URL of the WebSocket endpoint
+// of the given server.
+func webSocketURL(server *httptest.Server) string {
+	return strings.Replace(server.URL, "http", "ws", 1) + "/irc"
+}
+
+func TestWebSocketTextMessages(t *testing.T) {
+	server, peers := webSocketServer(t, WebSocketText, false)
+	defer server.Close()
+
+	conn, err := (&WebSocketDialer{URL: webSocketURL(server)}).Dial("tcp", "irc.example.com:6667")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	peer := <-peers
+
+	conn.Write([]byte("PRIVMSG #got :hello\r\nPART #go"))
+	conn.Write([]byte("t\r\n"))
+	peer.expect(wsText, "PRIVMSG #got :hello")
+	peer.expect(wsText, "PART #got")
+
+	peer.send(true, wsText, ":marvin!~marvin@hhgg.org PRIVMSG #got :hello?")
+	peer.send(false, wsText, ":marvin!~marvin@hhgg.org PRIVMSG #got ")
+	peer.send(true, wsContinuation, ":anyone there?\r\n")
+
+	buf := bufio.NewReader(conn)
+	line, _ := buf.ReadString('\n')
+	assert.Equal(t, ":marvin!~marvin@hhgg.org PRIVMSG #got :hello?\r\n", line)
+	line, _ = buf.ReadString('\n')
+	assert.Equal(t, ":marvin!~marvin@hhgg.org PRIVMSG #got :anyone there?\r\n", line)
+}
+
+func TestWebSocketBinaryMessages(t *testing.T) {
+	server, peers := webSocketServer(t, WebSocketBinary, false)
+	defer server.Close()
+
+	conn, err := (&WebSocketDialer{URL: webSocketURL(server)}).Dial("tcp", "irc.example.com:6667")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	peer := <-peers
+
+	conn.Write([]byte("PRIVMSG #got :caf\xe9\r\n"))
+	peer.expect(wsBinary, "PRIVMSG #got :caf\xe9")
+}
+
+func TestWebSocketControlFrames(t *testing.T) {
+	server, peers := webSocketServer(t, WebSocketText, false)
+	defer server.Close()
+
+	conn, err := (&WebSocketDialer{URL: webSocketURL(server)}).Dial("tcp", "irc.example.com:6667")
+	if !assert.Nil(t, err) {
+		return
+	}
+	peer := <-peers
+
+	read := make(chan error)
+	go func() {
+		_, err := conn.Read(make([]byte, 512))
+		read <- err
+	}()
+
+	peer.send(true, wsPing, "are you there?")
+	peer.expect(wsPong, "are you there?")
+
+	peer.send(true, wsClose, "")
+	peer.expect(wsClose, "")
+	assert.Equal(t, io.EOF, <-read)
+
+	conn.Close()
+}
+
+func TestWebSocketOverTLS(t *testing.T) {
+	server, peers := webSocketServer(t, WebSocketText, true)
+	defer server.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(server.Certificate())
+	d := &WebSocketDialer{URL: webSocketURL(server), TLSConfig: &tls.Config{RootCAs: pool}}
+
+	conn, err := d.Dial("tcp", "irc.example.com:6667")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer conn.Close()
+	peer := <-peers
+
+	conn.Write([]byte("PING :towel\r\n"))
+	peer.expect(wsText, "PING :towel")
+}
+
+func TestWebSocketHandshakeRejected(t *testing.T) {
+	server, _ := webSocketServer(t, WebSocketText, false)
+	defer server.Close()
+
+	_, err := (&WebSocketDialer{URL: webSocketURL(server) + "/nope"}).Dial("tcp", "irc.example.com:6667")
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), ErrWebSocketHandshake.Error())
+	}
+
+	_, err = (&WebSocketDialer{URL: "http://irc.example.com/"}).Dial("tcp", "irc.example.com:6667")
+	assert.NotNil(t, err)
+}
+
+func TestWebSocketLegacyEncodings(t *testing.T) {
+	for protocol, expected := range map[string]string{
+		WebSocketText:   "PRIVMSG #got :café",
+		WebSocketBinary: "PRIVMSG #got :caf\xe9",
+	} {
+		server, peers := webSocketServer(t, protocol, false)
+		defer server.Close()
+
+		irc, err := NewIRC(Config{
+			Dialer:   &WebSocketDialer{URL: webSocketURL(server)},
+			Channels: []ChannelConfig{{Name: "#got", Encoding: Latin1}},
+		})
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer irc.Close()
+		peer := <-peers
+
+		go irc.SendMessages("#got", "café")
+		opcode := byte(wsText)
+		if protocol == WebSocketBinary {
+			opcode = wsBinary
+		}
+		peer.expect(opcode, expected)
+	}
+}
+
+func TestNewIRCOverWebSocket(t *testing.T) {
+	server, peers := webSocketServer(t, WebSocketText, false)
+	defer server.Close()
+
+	irc, err := NewIRC(Config{Dialer: &WebSocketDialer{URL: webSocketURL(server)}})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer irc.Close()
+	peer := <-peers
+
+	go irc.Join("gotgotgot")
+	peer.expect(wsText, "CAP LS 302")
+	peer.expect(wsText, "NICK gotgotgot")
+}
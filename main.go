@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
+	"time"
 
 	"github.com/caiofilipini/got/bot"
 	"github.com/caiofilipini/got/command"
@@ -17,26 +22,203 @@ var (
 	channel     *string
 	user        *string
 	passwd      *string
+	serverPass  *string
 	logFilePath *string
+
+	networksFile *string
+
+	unixSocket *string
+	proxyURL   *string
+	wsURL      *string
+
+	useTLS        *bool
+	tlsCAFile     *string
+	tlsServerName *string
+	tlsInsecure   *bool
+	tlsCertFile   *string
+	tlsKeyFile    *string
+
+	saslMechanism *string
+	saslUser      *string
+	saslPassword  *string
+	saslAbort     *bool
+
+	capabilities *string
+
+	reconnectAttempts *int
+	pingInterval      *time.Duration
+	pingTimeout       *time.Duration
+
+	floodBurst    *int
+	floodInterval *time.Duration
+
+	maxLines *int
+
+	encoding         *string
+	fallbackEncoding *string
+	channelEncodings *string
+
+	noColors *string
+
+	ctcpVersion *string
+
+	quitMessage     *string
+	shutdownTimeout *time.Duration
+
+	altNicks         *string
+	nickServPassword *string
+	nickServRecover  *string
 )
 
 func init() {
 	server = flag.String("s", "irc.freenode.org", "IRC server host")
 	port = flag.Int("p", 6667, "IRC server port")
 	user = flag.String("u", "gotgotgot", "bot username")
-	channel = flag.String("c", "", "channel to connect")
-	passwd = flag.String("k", "", "channel secret key")
+	channel = flag.String("c", "", "comma-separated list of channels to join")
+	passwd = flag.String("k", "", "comma-separated list of channel secret keys, in the same order as the channels")
+	serverPass = flag.String("server-pass", "", "IRC server password, sent when registering")
 	logFilePath = flag.String("l", "", "log file location; if empty, stdout will be used")
 
+	networksFile = flag.String("networks", "", "JSON file with the networks to connect to, instead of the server, user and channel flags; the other flags apply to all of them")
+
+	unixSocket = flag.String("unix", "", "path to a Unix socket to connect to, instead of the server host and port")
+	proxyURL = flag.String("proxy", "", "proxy to connect through, as socks5://[user:pass@]host[:port] or http://[user:pass@]host[:port]")
+	wsURL = flag.String("websocket", "", "WebSocket endpoint to connect to, as ws:// or wss:// URL, instead of the server host and port")
+
+	useTLS = flag.Bool("tls", false, "connect using TLS; the port defaults to 6697")
+	tlsCAFile = flag.String("tls-ca", "", "PEM file with CA certificates to verify the server; if empty, the system pool will be used")
+	tlsServerName = flag.String("tls-servername", "", "server name used for SNI and certificate verification; defaults to the server host")
+	tlsInsecure = flag.Bool("tls-insecure", false, "skip server certificate verification (test networks only!)")
+	tlsCertFile = flag.String("tls-cert", "", "PEM file with the client certificate for CertFP authentication")
+	tlsKeyFile = flag.String("tls-key", "", "PEM file with the client certificate key")
+
+	saslMechanism = flag.String("sasl", "", "SASL mechanism used to authenticate (PLAIN or EXTERNAL); if empty, SASL is disabled")
+	saslUser = flag.String("sasl-user", "", "SASL account name; defaults to the bot username")
+	saslPassword = flag.String("sasl-pass", "", "SASL account password")
+	saslAbort = flag.Bool("sasl-abort", false, "abort if SASL authentication fails, instead of proceeding unauthenticated")
+
+	capabilities = flag.String("caps", "message-tags,server-time,account-tag,away-notify,multi-prefix,userhost-in-names", "comma-separated list of IRCv3 capabilities to request")
+
+	reconnectAttempts = flag.Int("reconnect-attempts", 0, "maximum number of consecutive connection attempts before giving up; 0 retries forever")
+	pingInterval = flag.Duration("ping-interval", irc.DefaultPingInterval, "how often to ping the server to measure the lag; a negative value disables pinging")
+	pingTimeout = flag.Duration("ping-timeout", irc.DefaultPingTimeout, "how long to wait for the server to reply to a ping before reconnecting")
+
+	floodBurst = flag.Int("flood-burst", irc.DefaultFloodBurst, "number of messages sent in a burst before flood control kicks in")
+	floodInterval = flag.Duration("flood-interval", irc.DefaultFloodInterval, "interval between messages after a burst; a negative value disables flood control")
+
+	maxLines = flag.Int("max-lines", 0, "maximum number of lines a long message is split into; 0 means no limit")
+
+	encoding = flag.String("encoding", "UTF-8", "charset used to send and receive messages (UTF-8, ISO-8859-1 or CP1252)")
+	fallbackEncoding = flag.String("fallback-encoding", "CP1252", "charset used to decode incoming messages that are not valid UTF-8")
+	channelEncodings = flag.String("channel-encodings", "", "comma-separated list of channel charsets, in the same order as the channels; empty entries use -encoding")
+
+	noColors = flag.String("no-colors", "", "comma-separated list of channels where colors are stripped from messages (e.g. with mode +c)")
+
+	ctcpVersion = flag.String("ctcp-version", irc.DefaultVersion, "reply to CTCP VERSION requests")
+
+	quitMessage = flag.String("quit-msg", irc.DefaultQuitMessage, "reason sent with QUIT when shutting down")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "how long to wait for pending messages to be sent when shutting down")
+
+	altNicks = flag.String("alt-nicks", "", "comma-separated list of nicknames to try if the bot username is taken")
+	nickServPassword = flag.String("nickserv-pass", "", "password used to identify with NickServ")
+	nickServRecover = flag.String("nickserv-recover", "", "how to recover the bot username from NickServ when taken (GHOST or REGAIN)")
+
 	flag.Parse()
 
-	if *channel == "" {
+	if *saslUser == "" {
+		*saslUser = *user
+	}
+
+	if *useTLS && !isFlagSet("p") {
+		*port = 6697
+	}
+
+	if *channel == "" && *networksFile == "" {
 		log.Println("No channel specified, aborting!")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 }
 
+// isFlagSet checks if the flag with the given name
+// was explicitly set in the command line.
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// splitList splits a comma-separated list of values,
+// ignoring empty ones.
+func splitList(list string) []string {
+	var values []string
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// channels builds the channel configuration from
+// the channel, key, encoding and color flags.
+func channels() ([]irc.ChannelConfig, error) {
+	keys := strings.Split(*passwd, ",")
+	encodings := strings.Split(*channelEncodings, ",")
+	colorless := splitList(*noColors)
+
+	var configs []irc.ChannelConfig
+	for i, name := range splitList(*channel) {
+		config := irc.ChannelConfig{Name: name}
+		if i < len(keys) {
+			config.Key = strings.TrimSpace(keys[i])
+		}
+		if i < len(encodings) && strings.TrimSpace(encodings[i]) != "" {
+			enc, err := irc.LookupEncoding(strings.TrimSpace(encodings[i]))
+			if err != nil {
+				return nil, err
+			}
+			config.Encoding = enc
+		}
+		for _, c := range colorless {
+			config.NoColors = config.NoColors || strings.EqualFold(c, name)
+		}
+		configs = append(configs, config)
+	}
+	return configs, nil
+}
+
+// dialer returns the dialer used to connect to the server,
+// or nil to connect over TCP.
+func dialer() (irc.Dialer, error) {
+	if *unixSocket != "" && *proxyURL != "" {
+		return nil, errors.New("-unix and -proxy can't be used together")
+	}
+	if *wsURL != "" && *useTLS {
+		return nil, errors.New("-tls can't be used with -websocket; use a wss:// URL instead")
+	}
+
+	var d irc.Dialer
+	if *unixSocket != "" {
+		d = &irc.UnixDialer{Path: *unixSocket}
+	}
+	if *proxyURL != "" {
+		var err error
+		if d, err = irc.ProxyDialer(*proxyURL, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	if *wsURL != "" {
+		return &irc.WebSocketDialer{URL: *wsURL, Dialer: d}, nil
+	}
+	return d, nil
+}
+
 func setupLogging() *os.File {
 	if *logFilePath != "" {
 		file, err := os.OpenFile(*logFilePath, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0666)
@@ -57,31 +239,150 @@ func main() {
 		defer logFile.Close()
 	}
 
-	conn := irc.NewIRC(*server, *port, *channel)
-	defer conn.Close()
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// flagsConfig builds the connection configuration from the flags.
+func flagsConfig() (irc.Config, error) {
+	d, err := dialer()
+	if err != nil {
+		return irc.Config{}, err
+	}
+
+	chans, err := channels()
+	if err != nil {
+		return irc.Config{}, err
+	}
+
+	enc, err := irc.LookupEncoding(*encoding)
+	if err != nil {
+		return irc.Config{}, err
+	}
+
+	fallback, err := irc.LookupEncoding(*fallbackEncoding)
+	if err != nil {
+		return irc.Config{}, err
+	}
+
+	return irc.Config{
+		Server:        *server,
+		Port:          *port,
+		Dialer:        d,
+		Password:      *serverPass,
+		Channels:      chans,
+		TLS:           *useTLS,
+		TLSCAFile:     *tlsCAFile,
+		TLSServerName: *tlsServerName,
+		TLSInsecure:   *tlsInsecure,
+		TLSCertFile:   *tlsCertFile,
+		TLSKeyFile:    *tlsKeyFile,
+
+		SASLMechanism:      *saslMechanism,
+		SASLUser:           *saslUser,
+		SASLPassword:       *saslPassword,
+		SASLAbortOnFailure: *saslAbort,
+
+		Capabilities: splitList(*capabilities),
+
+		ReconnectMaxAttempts: *reconnectAttempts,
 
-	bot := bot.NewBot(conn, *user, *passwd)
-	defer bot.Shutdown()
+		PingInterval: *pingInterval,
+		PingTimeout:  *pingTimeout,
 
-	// Register commands
-	bot.Register(command.Swear())
-	bot.Register(command.Greet())
-	bot.Register(command.Image())
-	bot.Register(command.GIF())
-	bot.Register(command.Video())
-	bot.Register(command.XKCD())
-	bot.Register(command.BeerOClock())
-	bot.Register(command.Weather())
-	bot.Register(command.Luca()) // tribute to lucapette
+		FloodBurst:    *floodBurst,
+		FloodInterval: *floodInterval,
 
-	bot.Start()
-	go bot.Listen()
+		MaxLines: *maxLines,
+
+		Encoding:         enc,
+		FallbackEncoding: fallback,
+
+		Version:     *ctcpVersion,
+		QuitMessage: *quitMessage,
+
+		AltNicks:         splitList(*altNicks),
+		NickServPassword: *nickServPassword,
+		NickServRecover:  *nickServRecover,
+	}, nil
+}
+
+// registry returns the commands available to the bots
+// on all the networks.
+func registry() *bot.Registry {
+	r := bot.NewRegistry()
+	r.Register(command.Swear())
+	r.Register(command.Greet())
+	r.Register(command.Image())
+	r.Register(command.GIF())
+	r.Register(command.Video())
+	r.Register(command.XKCD())
+	r.Register(command.BeerOClock())
+	r.Register(command.Weather())
+	r.Register(command.Lag())
+	r.Register(command.Luca()) // tribute to lucapette
+	return r
+}
+
+// run starts a bot on each of the networks and runs them until
+// interrupted, or until the connections are lost for good,
+// shutting them down gracefully.
+func run() error {
+	base, err := flagsConfig()
+	if err != nil {
+		return err
+	}
+
+	networks := []network{{Name: *server, Nick: *user}}
+	configs := []irc.Config{base}
+	if *networksFile != "" {
+		if networks, err = loadNetworks(*networksFile); err != nil {
+			return err
+		}
+		configs = nil
+		for _, n := range networks {
+			config, err := n.config(base)
+			if err != nil {
+				return fmt.Errorf("%s: %v", n.Name, err)
+			}
+			configs = append(configs, config)
+		}
+	}
+
+	commands := registry()
+	for _, n := range networks {
+		if err := commands.Check(n.Commands...); err != nil {
+			return fmt.Errorf("%s: %v", n.Name, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt)
-
-	for _ = range signals {
+	go func() {
+		<-signals
 		log.Println("KTHXBAI.")
-		os.Exit(0)
+		cancel()
+	}()
+
+	// Each network is connected on its own, so that one that's
+	// unreachable doesn't hold up or bring down the others.
+	errs := make(chan error, len(networks))
+	for i, n := range networks {
+		go func(n network, config irc.Config) {
+			errs <- n.run(ctx, config, commands)
+		}(n, configs[i])
+	}
+
+	// Keep going while any of the networks is up.
+	for range networks {
+		err = <-errs
 	}
+	if ctx.Err() == nil {
+		return fmt.Errorf("Connection lost: %v", err)
+	}
+	return nil
 }
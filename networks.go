@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/caiofilipini/got/bot"
+	"github.com/caiofilipini/got/irc"
+)
+
+// network holds the settings of one of the networks to connect to,
+// as read from the networks file. The server, nick and channels are
+// required; the other settings left empty are taken from the flags.
+type network struct {
+	// The network name, used in the logs (e.g. "libera").
+	Name string `json:"name"`
+
+	// The IRC server to connect to, and its port.
+	Server string `json:"server"`
+	Port   int    `json:"port"`
+
+	// Whether the connection should use TLS.
+	TLS bool `json:"tls"`
+
+	// The server password, sent when registering.
+	Password string `json:"password"`
+
+	// The proxy to connect through, in the same form as -proxy.
+	Proxy string `json:"proxy"`
+
+	// The bot username, and the nicknames to try if it's taken.
+	Nick     string   `json:"nick"`
+	AltNicks []string `json:"alt_nicks"`
+
+	// The password used to identify with NickServ.
+	NickServPassword string `json:"nickserv_password"`
+
+	// The SASL mechanism and credentials; the account name
+	// defaults to -sasl-user, if set, or to the bot username.
+	SASLMechanism string `json:"sasl"`
+	SASLUser      string `json:"sasl_user"`
+	SASLPassword  string `json:"sasl_password"`
+
+	// The charset used to send and receive messages.
+	Encoding string `json:"encoding"`
+
+	// The channels to join.
+	Channels []networkChannel `json:"channels"`
+
+	// The names of the commands the bot answers to on the
+	// network. If empty, it answers to all of them.
+	Commands []string `json:"commands"`
+}
+
+// networkChannel holds the settings of a channel, as read
+// from the networks file.
+type networkChannel struct {
+	// The channel name, including the prefix (e.g. "#got").
+	Name string `json:"name"`
+
+	// The channel secret key, if any.
+	Key string `json:"key"`
+
+	// The charset of the channel, if it differs from the
+	// network one.
+	Encoding string `json:"encoding"`
+
+	// Whether to strip colors from the messages sent
+	// to the channel (e.g. with mode +c).
+	NoColors bool `json:"no_colors"`
+}
+
+// loadNetworks reads the networks to connect to from the given
+// JSON file, which holds a list of networks.
+func loadNetworks(path string) ([]network, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var networks []network
+	if err := json.Unmarshal(data, &networks); err != nil {
+		return nil, fmt.Errorf("Invalid networks file %s: %v", path, err)
+	}
+	if len(networks) == 0 {
+		return nil, fmt.Errorf("No networks in %s", path)
+	}
+
+	for i, n := range networks {
+		if n.Server == "" || n.Nick == "" || len(n.Channels) == 0 {
+			return nil, fmt.Errorf("Network %d in %s needs a server, a nick and channels", i+1, path)
+		}
+		if n.Name == "" {
+			networks[i].Name = n.Server
+		}
+	}
+	return networks, nil
+}
+
+// config returns the connection configuration for the network,
+// based on the given one, which was built from the flags.
+func (n network) config(base irc.Config) (irc.Config, error) {
+	config := base
+	config.Server = n.Server
+
+	if n.TLS {
+		config.TLS = true
+		if !base.TLS && !isFlagSet("p") {
+			config.Port = 6697
+		}
+	}
+	if n.Port != 0 {
+		config.Port = n.Port
+	}
+	if n.Password != "" {
+		config.Password = n.Password
+	}
+	if n.Proxy != "" {
+		d, err := irc.ProxyDialer(n.Proxy, nil)
+		if err != nil {
+			return irc.Config{}, err
+		}
+		config.Dialer = d
+	}
+
+	if n.AltNicks != nil {
+		config.AltNicks = n.AltNicks
+	}
+	if n.NickServPassword != "" {
+		config.NickServPassword = n.NickServPassword
+	}
+	if n.SASLMechanism != "" {
+		config.SASLMechanism = n.SASLMechanism
+		config.SASLPassword = n.SASLPassword
+	}
+	switch {
+	case n.SASLUser != "":
+		config.SASLUser = n.SASLUser
+	case config.SASLMechanism != "" && !isFlagSet("sasl-user"):
+		// The -sasl-user flag defaults to -u, which
+		// is not the bot username on this network.
+		config.SASLUser = n.Nick
+	}
+
+	if n.Encoding != "" {
+		enc, err := irc.LookupEncoding(n.Encoding)
+		if err != nil {
+			return irc.Config{}, err
+		}
+		config.Encoding = enc
+	}
+
+	config.Channels = nil
+	for _, c := range n.Channels {
+		channel := irc.ChannelConfig{Name: c.Name, Key: c.Key, NoColors: c.NoColors}
+		if c.Encoding != "" {
+			enc, err := irc.LookupEncoding(c.Encoding)
+			if err != nil {
+				return irc.Config{}, err
+			}
+			channel.Encoding = enc
+		}
+		config.Channels = append(config.Channels, channel)
+	}
+	return config, nil
+}
+
+// run connects to the network with the given configuration and
+// starts a bot with the enabled commands from the given registry,
+// then runs it until the given context is done, returning its
+// error, or until the connection is lost for good, shutting both
+// down gracefully.
+func (n network) run(ctx context.Context, config irc.Config, commands *bot.Registry) error {
+	conn, err := n.connect(ctx, config)
+	if err != nil {
+		if err != context.Canceled {
+			log.Printf("Unable to connect to %s: %v\n", n.Name, err)
+		}
+		return err
+	}
+	defer conn.Close()
+
+	b := bot.NewBotWithRegistry(conn, n.Nick, commands)
+	if err := b.Enable(n.Commands...); err != nil {
+		return fmt.Errorf("%s: %v", n.Name, err)
+	}
+	if err := b.Start(); err != nil {
+		log.Printf("Unable to start the bot on %s: %v\n", n.Name, err)
+		return err
+	}
+
+	go b.Run(ctx)
+	err = conn.Run(ctx)
+	if err != context.Canceled {
+		log.Printf("Connection to %s lost: %v\n", n.Name, err)
+	}
+
+	// Stop the bot first, so that its answers are sent before quitting.
+	shutdown, cancelShutdown := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancelShutdown()
+	b.Shutdown(shutdown)
+	conn.Shutdown(shutdown)
+
+	return err
+}
+
+// connect connects to the network, giving up if the given
+// context is done first.
+func (n network) connect(ctx context.Context, config irc.Config) (*irc.IRC, error) {
+	log.Printf("Connecting to %s (%s)\n", n.Name, config.Server)
+
+	type result struct {
+		conn *irc.IRC
+		err  error
+	}
+	connected := make(chan result, 1)
+	go func() {
+		conn, err := irc.NewIRC(config)
+		connected <- result{conn, err}
+	}()
+
+	select {
+	case r := <-connected:
+		return r.conn, r.err
+	case <-ctx.Done():
+		// Connecting can't be interrupted, so the connection
+		// is closed if it's established afterwards.
+		go func() {
+			if r := <-connected; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}